@@ -0,0 +1,258 @@
+package webgo
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/IntelliQru/i18n"
+)
+
+type (
+	// action - описание действия контроллера, разобранное один раз при регистрации маршрута
+	action struct {
+		index        int
+		args         []argBinder
+		returnsError bool
+	}
+	// argBinder возвращает значение параметра действия для запроса
+	argBinder func(ctx *Context) (reflect.Value, error)
+)
+
+var (
+	errorType           = reflect.TypeOf((*error)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// newAction проверяет сигнатуру действия и готовит привязку его параметров.
+// Скалярные параметры связываются с параметрами маршрута по порядку и должны покрывать их все,
+// структуры (или указатели на структуры) заполняются по тегам, как в Context.ValidateSchema.
+// Действие может возвращать только error.
+func newAction(controller reflect.Type, name string, keys []string) (*action, error) {
+	method, ok := reflect.PtrTo(controller).MethodByName(name)
+	if !ok {
+		return nil, fmt.Errorf("Not found action '%s'", name)
+	}
+
+	// Первый аргумент метода - получатель
+	actionType := method.Type
+	act := &action{index: method.Index}
+
+	switch {
+	case actionType.NumOut() > 1:
+		return nil, fmt.Errorf("Invalid action signature '%s' %s", name, actionType)
+	case actionType.NumOut() == 1:
+		if actionType.Out(0) != errorType {
+			return nil, fmt.Errorf("Invalid action signature '%s' %s", name, actionType)
+		}
+		act.returnsError = true
+	}
+
+	pos := 0
+	for i := 1; i < actionType.NumIn(); i++ {
+		in := actionType.In(i)
+
+		if isScalar(in) {
+			if pos >= len(keys) {
+				return nil, fmt.Errorf("Action '%s' param #%d %s has no route param", name, i, in)
+			}
+			act.args = append(act.args, paramBinder(in, keys[pos]))
+			pos++
+			continue
+		}
+
+		typ := in
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("Action '%s' param #%d has unsupported type %s", name, i, in)
+		}
+
+		s, err := schemaOf(typ)
+		if err != nil {
+			return nil, fmt.Errorf("Action '%s' param #%d: %s", name, i, err)
+		}
+		if !s.tagged && !s.json {
+			return nil, fmt.Errorf("Action '%s' param #%d: struct %s has no binding tags", name, i, typ)
+		}
+		if _, err = validationOf(typ); err != nil {
+			return nil, fmt.Errorf("Action '%s' param #%d: %s", name, i, err)
+		}
+		act.args = append(act.args, schemaBinder(s, in.Kind() == reflect.Ptr))
+	}
+
+	// Позиционная привязка неоднозначна, если связана только часть параметров маршрута:
+	// для /users/:uid/posts/:id действие Show(id int) получило бы uid
+	if pos > 0 && pos != len(keys) {
+		return nil, fmt.Errorf("Action '%s' binds %d of %d route params, use a struct with path tags", name, pos, len(keys))
+	}
+
+	return act, nil
+}
+
+// bind возвращает аргументы действия для запроса
+func (a *action) bind(ctx *Context) ([]reflect.Value, error) {
+	in := make([]reflect.Value, len(a.args))
+	for i, binder := range a.args {
+		val, err := binder(ctx)
+		if err != nil {
+			return nil, err
+		}
+		in[i] = val
+	}
+	return in, nil
+}
+
+// paramBinder связывает параметр действия с параметром маршрута.
+// Указатель остается nil, если параметр не передан, например для :name?.
+func paramBinder(typ reflect.Type, key string) argBinder {
+	return func(ctx *Context) (reflect.Value, error) {
+		val := reflect.New(typ).Elem()
+
+		str, ok := ctx.Params[key]
+		if !ok || str == "" {
+			return val, nil
+		}
+
+		if err := setValue(val, str); err != nil {
+			return val, ValidationErrors{newFieldError(i18n.Tfunc(ctx.Lang), key, "type", typeName(typ), str)}
+		}
+		return val, nil
+	}
+}
+
+// schemaBinder заполняет структуру по тегам: сначала тело JSON, затем остальные источники
+func schemaBinder(s *schema, ptr bool) argBinder {
+	return func(ctx *Context) (reflect.Value, error) {
+		val := reflect.New(s.typ)
+
+		var errs ValidationErrors
+		if ctx.isJSON() && len(ctx._Body) > 0 {
+			var err error
+			if errs, err = ctx.decodeJSON(val.Interface()); err != nil {
+				return val, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid JSON body", Err: err}
+			}
+		}
+
+		if err := ctx.bindSchema(val.Elem(), errs); err != nil {
+			return val, err
+		}
+
+		if ptr {
+			return val, nil
+		}
+		return val.Elem(), nil
+	}
+}
+
+// queryValues приводит значение Context.Query к списку строк
+func queryValues(val interface{}) []string {
+	switch v := val.(type) {
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
+// tagName возвращает имя из тега без опций, "-" означает отсутствие имени
+func tagName(tag string) string {
+	name := strings.Split(tag, ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// isScalar проверяет, что значение типа можно получить из одной строки
+func isScalar(typ reflect.Type) bool {
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return true
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+		if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+			return true
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setValues записывает значения в поле, для срезов каждое значение становится элементом
+func setValues(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && !reflect.PtrTo(field.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, str := range values {
+			if err := setValue(slice.Index(i), str); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	return setValue(field, values[0])
+}
+
+// setValue преобразует строку к типу значения
+func setValue(val reflect.Value, str string) error {
+	if val.Kind() == reflect.Ptr {
+		ptr := reflect.New(val.Type().Elem())
+		if err := setValue(ptr.Elem(), str); err != nil {
+			return err
+		}
+		val.Set(ptr)
+		return nil
+	}
+
+	if u, ok := val.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(str))
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		val.SetString(str)
+	case reflect.Bool:
+		res, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		val.SetBool(res)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		res, err := strconv.ParseInt(str, 10, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetInt(res)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		res, err := strconv.ParseUint(str, 10, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetUint(res)
+	case reflect.Float32, reflect.Float64:
+		res, err := strconv.ParseFloat(str, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetFloat(res)
+	default:
+		return errors.New("Unsupported type " + val.Type().String())
+	}
+
+	return nil
+}
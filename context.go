@@ -2,17 +2,19 @@ package webgo
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"reflect"
-	"strconv"
 	"strings"
-	"time"
+
+	"github.com/IntelliQru/i18n"
 )
 
+// Files - загруженные файлы multipart-запроса во временном каталоге системы.
+// Те же файлы доступны через Request.MultipartForm.File и Request.FormFile.
 type Files []File
 type File struct {
 	Path string
@@ -37,7 +39,7 @@ type Context struct {
 	Action      string
 	Query       map[string]interface{}
 	Files       Files
-	Params      map[string]string
+	Params      Params
 	_Body       []byte
 	Body        map[string]interface{}
 	User        map[string]interface{}
@@ -49,198 +51,159 @@ type Context struct {
 	close       bool
 	isCustomResponse  bool // Это костыль, нужно переделать, чтобы не поломать АПИ текущих проектов
 	Lang        string
+	options     *RouteOptions
+	decoder     Decoder
+	multipart   *multipart.Reader
+	fileCount   int
+	session     *Session
+	committed   bool
+	flashes     []Flash
+	flashesIn   []Flash
+	flashesRead bool
+	csrf        *CSRF
+	csrfSecret  []byte
+	data        interface{}
+	dataErr     error
+	dataParsed  bool
 }
 
-func (c *Context) GetBody() []byte {
-	return c._Body
-}
-
-func (c *Context) GetCookie(key string) string {
-	val, err := c.Request.Cookie(key)
-	if err != nil {
-		return ""
+// newContext создает контекст запроса, route может быть nil, если маршрут не найден
+func newContext(w http.ResponseWriter, r *http.Request, route *Match) *Context {
+	ctx := &Context{
+		Response: w,
+		Request:  r,
+		Query:    make(map[string]interface{}),
+		Body:     make(map[string]interface{}),
+		Params:   make(Params),
+		Method:   r.Method,
+		Lang:     app.defaultLang,
 	}
-	return val.Value
-}
 
-// Порядок params - MaxAge, Path, Domain, HttpOnly, Secure
-// Внимание! HttpOnly для сессий необходимо передавать true!!! Это органичет доступ к кукам JS в браузере
-func (c *Context) SetCookie(name string, val string, params ...interface{}) {
-	var cookie bytes.Buffer
-
-	// Очищаем спец символы
-	nameCleaner := strings.NewReplacer("\n", "-", "\r", "-")
-	name = nameCleaner.Replace(name)
+	if route != nil {
+		ctx.Action = route.Options.Action
+		ctx.Params = route.Params
+		ctx.options = route.Options
+	}
 
-	valueCleaner := strings.NewReplacer("\n", " ", "\r", " ", ";", " ")
-	val = valueCleaner.Replace(val)
+	return ctx
+}
 
-	fmt.Fprintf(&cookie, "%s=%s", name, val)
+// removeFiles удаляет временные файлы загрузки
+func (c *Context) removeFiles() {
+	err := c.Files.RemoveAll()
+	if err != nil {
+		LOGGER.Error(err)
+	}
+	c.Files = nil
 
-	ln := len(params)
+	if c.Request.MultipartForm != nil {
+		err = c.Request.MultipartForm.RemoveAll()
+		if err != nil {
+			LOGGER.Error(err)
+		}
+	}
+}
 
-	if ln > 0 {
-		var maxAge int64
+// detectLang выбирает язык из cookie lang или первый поддерживаемый язык из Accept-Language
+func (c *Context) detectLang() {
+	cookieLang := c.GetCookie("lang")
+	if len(cookieLang) != 0 && i18n.CheckLang(cookieLang) {
+		c.Lang = cookieLang
+		return
+	}
 
-		switch v := params[0].(type) {
-		case int:
-			maxAge = int64(v)
-		case int32:
-			maxAge = int64(v)
-		case int64:
-			maxAge = v
+	for _, item := range strings.Split(c.Request.Header.Get("Accept-Language"), ",") {
+		tag := item
+		if i := strings.IndexByte(item, ';'); i >= 0 {
+			tag = item[:i]
 		}
 
-		if maxAge > 0 {
-			fmt.Fprintf(&cookie, "; Expires=%s; Max-Age=%d", time.Now().Add(time.Duration(maxAge)*time.Second).UTC().Format(time.RFC1123), maxAge)
-		} else {
-			fmt.Fprintf(&cookie, "; Max-Age=0")
+		tag = strings.TrimSpace(tag)
+		if tag != "" && i18n.CheckLang(tag) {
+			c.Lang = tag
+			return
 		}
 	}
+}
 
-	// Устанавливаем Path
-	if ln > 1 {
-		if v, ok := params[1].(string); ok && v != "" {
-			fmt.Fprintf(&cookie, "; Path=%s", valueCleaner.Replace(v))
-		}
-	} else {
-		fmt.Fprintf(&cookie, "; Path=%s", "/")
-	}
+func (c *Context) GetBody() []byte {
+	return c._Body
+}
 
-	// Устанавливаем Domain
-	if ln > 2 {
-		if v, ok := params[2].(string); ok && v != "" {
-			fmt.Fprintf(&cookie, "; Domain=%s", valueCleaner.Replace(v))
-		}
+// Data возвращает тело, разобранное декодером типа содержимого (см. RegisterDecoder).
+// Для JSON это map[string]interface{}, []interface{}, string, json.Number, bool или nil.
+// Тело разбирается один раз (потоковое - при первом обращении), объект попадает и в Body.
+// Числа JSON возвращаются как json.Number без потери точности, в Body - так же.
+func (c *Context) Data() (interface{}, error) {
+	if c.dataParsed {
+		return c.data, c.dataErr
 	}
+	c.dataParsed = true
 
-	// Устанавливаем HttpOnly
-	if ln > 3 {
-		if v, ok := params[3].(bool); ok && v {
-			fmt.Fprintf(&cookie, "; HttpOnly")
-		}
+	if c.decoder == nil {
+		c.dataErr = errors.New("Unsupported content type: '" + c.ContentType + "'")
+		return nil, c.dataErr
 	}
 
-	// Устанавливаем Secure
-	if ln > 4 {
-		var secure bool
-		switch v := params[4].(type) {
-		case bool:
-			secure = v
-		default:
-			if params[4] != nil {
-				secure = true
-			}
-		}
+	if len(c._Body) > 0 || isStream(c.decoder) {
+		c.dataErr = c.decoder.Decode(c.bodyReader(), &c.data)
+	}
 
-		if secure {
-			fmt.Fprintf(&cookie, "; Secure")
-		}
+	return c.data, c.dataErr
+}
 
+// bodyReader возвращает тело запроса, потоковое тело читается из Request.Body
+func (c *Context) bodyReader() io.Reader {
+	if isStream(c.decoder) {
+		return c.Request.Body
 	}
+	return bytes.NewReader(c._Body)
+}
 
-	c.Response.Header().Add("Set-Cookie", cookie.String())
+// isJSON проверяет тип содержимого application/json или application/*+json
+func (c *Context) isJSON() bool {
+	return c.ContentType == CT_JSON || strings.HasSuffix(c.ContentType, "+json")
 }
 
+// ValidateSchema заполняет структуру schema данными запроса и проверяет ее правилами validate.
+// Тело JSON разбирается по тегам json, другие типы - декодером (XML по тегам xml), значения формы, multipart и строки запроса
+// (для запроса без тела) связываются по тегам form, остальные поля - по тегам
+// query, header, cookie и path. Ошибки значений и правил возвращаются списком ValidationErrors.
 func (c *Context) ValidateSchema(schema interface{}) (err error) {
 
-	switch c.ContentType {
-	case CT_JSON:
-		err = json.Unmarshal(c._Body, schema)
-	case CT_FORM, CT_MULTIPART:
-
-		schemaType := reflect.TypeOf(schema)
-
-		if schemaType.Kind() == reflect.Ptr {
-			schemaType = reflect.ValueOf(schema).Elem().Type()
-		}
+	var errs ValidationErrors
 
-		if schemaType.Kind() != reflect.Struct {
-			err = errors.New("Invalid validation struct type: " + schemaType.Kind().String())
+	switch {
+	case c.isJSON():
+		errs, err = c.decodeJSON(schema)
+		if err != nil {
 			return
 		}
-
-		schemaValue := reflect.ValueOf(schema).Elem()
-
-		for key, iVal := range c.Body {
-
-			val := iVal.([]string)
-
-			if len(val) == 0 {
-				continue
-			}
-
-			field := schemaValue.FieldByName(key)
-
-			if field.IsValid() {
-
-				if field.Kind() == reflect.Slice {
-
-					// Get kind of slice elements type
-					arrElemKind := field.Type().Elem().Kind()
-
-					for _, inValue := range val {
-
-						switch arrElemKind {
-						case reflect.String:
-							field.Set(reflect.Append(field, reflect.ValueOf(inValue)))
-						case reflect.Int:
-							setVal, e := strconv.Atoi(inValue)
-							if e != nil {
-								err = errors.New("Invalid value '" + inValue + "' for key '" + key + "', must be Integer")
-								return
-							}
-							field.Set(reflect.Append(field, reflect.ValueOf(setVal)))
-						case reflect.Float64:
-							setVal, e := strconv.ParseFloat(inValue, 64)
-							if e != nil {
-								err = errors.New("Invalid value '" + inValue + "' for key '" + key + "', must be Float64")
-								return
-							}
-							field.Set(reflect.Append(field, reflect.ValueOf(setVal)))
-						default:
-							err = errors.New("Unsupported field type: " + arrElemKind.String())
-							return
-						}
-					}
-
-				} else {
-					if len(val) > 1 {
-						err = errors.New("Invalid array value for key '" + key + "'")
-						return
-					}
-
-					fieldKind := field.Kind()
-
-					switch fieldKind {
-					case reflect.String:
-						field.SetString(val[0])
-					case reflect.Int:
-						setVal, e := strconv.Atoi(val[0])
-						if e != nil {
-							err = errors.New("Invalid value '" + val[0] + "' for key '" + key + "', must be Integer")
-							return
-						}
-						field.SetInt(int64(setVal))
-					case reflect.Float64:
-						setVal, e := strconv.ParseFloat(val[0], 64)
-						if e != nil {
-							err = errors.New("Invalid value '" + val[0] + "' for key '" + key + "', must be Float64")
-							return
-						}
-						field.SetFloat(setVal)
-					default:
-						err = errors.New("Unsupported field type: " + fieldKind.String())
-						return
-					}
-				}
+	case c.ContentType == CT_FORM, c.ContentType == CT_MULTIPART, c.ContentType == "":
+	case c.decoder != nil:
+		if len(c._Body) > 0 || isStream(c.decoder) {
+			err = c.decoder.Decode(c.bodyReader(), schema)
+			if err != nil {
+				return
 			}
 		}
 	default:
 		return errors.New("Invalid content type")
 	}
 
-	return
+	schemaValue := reflect.ValueOf(schema)
+	if schemaValue.Kind() != reflect.Ptr || schemaValue.Elem().Kind() != reflect.Struct {
+		// Декодеры могут разбирать и в другие типы, например map
+		if c.decoder != nil {
+			if len(errs) > 0 {
+				return errs
+			}
+			return
+		}
+		return errors.New("Invalid validation struct type: " + schemaValue.Kind().String())
+	}
+
+	return c.bindSchema(schemaValue.Elem(), errs)
 }
 
 func (c *Context) IsRedirect() bool {
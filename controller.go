@@ -30,6 +30,7 @@ type (
 		SetOutput(data []byte)
 
 		Redirect(location string, code int)
+		Flash(kind string, msg string, args ...interface{})
 
 		SendFile(filepath string) (err error)
 		Render(tpl_name string, data interface{})
@@ -119,7 +120,7 @@ func (c Controller) CustomResponse() {
 
 func (c Controller) Render(tpl_name string, data interface{}) {
 	bytes := bytes.NewBufferString("")
-	c.Ctx.error = app.templates.ExecuteTemplate(bytes, tpl_name+".html", data)
+	c.Ctx.error = app.executeTemplate(bytes, c.Ctx, tpl_name+".html", data)
 	if c.Ctx.error != nil {
 		return
 	}
@@ -158,12 +159,7 @@ func (c Controller) Plain(data string) {
 
 func (c Controller) exec() {
 	if c.Ctx.error != nil {
-		LOGGER.Error(c.Ctx.error)
-		if c.Ctx.code == 0 {
-			c.Ctx.code = 500
-		}
-		c.Ctx.Response.WriteHeader(c.Ctx.code)
-		c.Ctx.Response.Write(c.Ctx.output)
+		app.handleError(c.Ctx, c.Ctx.error)
 		return
 	}
 
@@ -0,0 +1,245 @@
+package webgo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieDefaults - атрибуты, которые приложение добавляет ко всем cookie ответа.
+// Secure и HttpOnly включаются, даже если в cookie они не заданы, SameSite
+// подставляется, если не задан в cookie.
+type CookieDefaults struct {
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// newCookieDefaults читает настройки cookieSecure, cookieHttpOnly и cookieSameSite (lax, strict, none)
+func newCookieDefaults() (CookieDefaults, error) {
+	sameSite, ok := sameSiteModes[strings.ToLower(CFG.Str("cookieSameSite"))]
+	if !ok {
+		return CookieDefaults{}, fmt.Errorf("Unknown cookieSameSite mode: '%s'.", CFG.Str("cookieSameSite"))
+	}
+
+	return CookieDefaults{
+		Secure:   cfgBool("cookieSecure"),
+		HttpOnly: cfgBool("cookieHttpOnly"),
+		SameSite: sameSite,
+	}, nil
+}
+
+func (a *App) SetCookieDefaults(defaults CookieDefaults) {
+	a.cookieDefaults = defaults
+}
+
+func SetCookieDefaults(defaults CookieDefaults) {
+	app.SetCookieDefaults(defaults)
+}
+
+// GetCookie возвращает значение cookie, закодированное SetCookie, в исходном виде
+func (c *Context) GetCookie(key string) string {
+	val, err := c.Request.Cookie(key)
+	if err != nil {
+		return ""
+	}
+	return unescapeCookieValue(val.Value)
+}
+
+// SetCookieOpts добавляет cookie в ответ с атрибутами приложения по умолчанию.
+// Значение кодируется так, чтобы GetCookie вернул его без изменений, cookie не меняется.
+// Атрибут Partitioned задается через Unparsed: []string{"Partitioned"}, поле
+// http.Cookie.Partitioned есть не во всех поддерживаемых версиях Go.
+func (c *Context) SetCookieOpts(cookie *http.Cookie) {
+	c.setCookie(cookie, app.cookieDefaults)
+}
+
+// setCookie добавляет cookie в ответ с атрибутами defaults
+func (c *Context) setCookie(cookie *http.Cookie, defaults CookieDefaults) {
+	ck := *cookie
+	ck.Value = escapeCookieValue(ck.Value)
+
+	partitioned := false
+	for _, attr := range ck.Unparsed {
+		if strings.EqualFold(strings.TrimSpace(attr), "Partitioned") {
+			partitioned = true
+		}
+	}
+
+	ck.Secure = ck.Secure || defaults.Secure
+	ck.HttpOnly = ck.HttpOnly || defaults.HttpOnly
+	if ck.SameSite == 0 {
+		ck.SameSite = defaults.SameSite
+	}
+	// Браузеры отклоняют SameSite=None без Secure
+	if ck.SameSite == http.SameSiteNoneMode || partitioned {
+		ck.Secure = true
+	}
+
+	if ck.MaxAge > 0 && ck.Expires.IsZero() {
+		ck.Expires = time.Now().Add(time.Duration(ck.MaxAge) * time.Second)
+	}
+
+	header := ck.String()
+	if header == "" {
+		LOGGER.Error(fmt.Errorf("Invalid cookie name: '%s'.", cookie.Name))
+		return
+	}
+	if partitioned && !strings.HasSuffix(header, "; Partitioned") {
+		header += "; Partitioned"
+	}
+	c.Response.Header().Add("Set-Cookie", header)
+}
+
+// DeleteCookie удаляет cookie у клиента. Путь и домен должны совпадать с теми,
+// с которыми cookie была установлена, пустой путь означает "/".
+func (c *Context) DeleteCookie(name, path, domain string) {
+	if path == "" {
+		path = "/"
+	}
+
+	c.SetCookieOpts(&http.Cookie{
+		Name:    name,
+		Path:    path,
+		Domain:  domain,
+		MaxAge:  -1,
+		Expires: time.Unix(0, 0),
+	})
+}
+
+// Порядок params - MaxAge, Path, Domain, HttpOnly, Secure, SameSite (http.SameSite или "lax", "strict", "none").
+// MaxAge <= 0 удаляет cookie. Для новых атрибутов используйте SetCookieOpts.
+// Внимание! HttpOnly для сессий необходимо передавать true!!! Это органичет доступ к кукам JS в браузере
+func (c *Context) SetCookie(name string, val string, params ...interface{}) {
+	cookie := &http.Cookie{Name: name, Value: val, Path: "/"}
+
+	ln := len(params)
+
+	if ln > 0 {
+		var maxAge int64
+
+		switch v := params[0].(type) {
+		case int:
+			maxAge = int64(v)
+		case int32:
+			maxAge = int64(v)
+		case int64:
+			maxAge = v
+		}
+
+		cookie.MaxAge = int(maxAge)
+		if maxAge <= 0 {
+			cookie.MaxAge = -1
+		}
+	}
+
+	// Устанавливаем Path
+	if ln > 1 {
+		cookie.Path, _ = params[1].(string)
+	}
+
+	// Устанавливаем Domain
+	if ln > 2 {
+		cookie.Domain, _ = params[2].(string)
+	}
+
+	// Устанавливаем HttpOnly
+	if ln > 3 {
+		cookie.HttpOnly, _ = params[3].(bool)
+	}
+
+	// Устанавливаем Secure
+	if ln > 4 {
+		switch v := params[4].(type) {
+		case bool:
+			cookie.Secure = v
+		default:
+			cookie.Secure = params[4] != nil
+		}
+	}
+
+	// Устанавливаем SameSite
+	if ln > 5 {
+		sameSite, ok := params[5].(http.SameSite)
+		if str, isStr := params[5].(string); isStr {
+			sameSite, ok = sameSiteModes[strings.ToLower(str)]
+		}
+		if ok {
+			cookie.SameSite = sameSite
+		}
+	}
+
+	c.SetCookieOpts(cookie)
+}
+
+// escapeCookieValue кодирует символы, недопустимые в значении cookie (RFC 6265), и сам '%' как %XX
+func escapeCookieValue(val string) string {
+	const hex = "0123456789ABCDEF"
+
+	var buf strings.Builder
+	for i := 0; i < len(val); i++ {
+		b := val[i]
+		if validCookieByte(b) && b != '%' {
+			if buf.Len() > 0 {
+				buf.WriteByte(b)
+			}
+			continue
+		}
+
+		if buf.Len() == 0 {
+			buf.WriteString(val[:i])
+		}
+		buf.WriteByte('%')
+		buf.WriteByte(hex[b>>4])
+		buf.WriteByte(hex[b&15])
+	}
+
+	if buf.Len() == 0 {
+		return val
+	}
+	return buf.String()
+}
+
+// unescapeCookieValue декодирует %XX, значение с ошибочной последовательностью возвращается как есть
+func unescapeCookieValue(val string) string {
+	if !strings.Contains(val, "%") {
+		return val
+	}
+
+	buf := make([]byte, 0, len(val))
+	for i := 0; i < len(val); i++ {
+		if val[i] != '%' {
+			buf = append(buf, val[i])
+			continue
+		}
+
+		if i+2 >= len(val) {
+			return val
+		}
+		hi, ok1 := fromHex(val[i+1])
+		lo, ok2 := fromHex(val[i+2])
+		if !ok1 || !ok2 {
+			return val
+		}
+		buf = append(buf, hi<<4|lo)
+		i += 2
+	}
+	return string(buf)
+}
+
+func validCookieByte(b byte) bool {
+	return 0x20 < b && b < 0x7f && b != '"' && b != ',' && b != ';' && b != '\\'
+}
+
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package webgo
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRF - middleware защиты от подделки межсайтовых запросов. Для небезопасных методов
+// проверяет Origin (или Referer) и токен из поля формы либо заголовка.
+// Токен хранится в сессии, а если сессии отключены или задан DoubleSubmit - в cookie.
+// Маршруты с RouteOptions.CSRFExempt не проверяются.
+//
+//	RegisterMiddleware("web", webgo.NewCSRF())
+//
+// В шаблонах токен доступен через {{csrfField}} (скрытое поле формы) и {{csrfToken}}.
+// Для маршрутов с Handler и StreamMultipart тело не разбирается заранее, токен передается заголовком.
+type CSRF struct {
+	FieldName      string   // Поле формы, по умолчанию _csrf
+	HeaderName     string   // Заголовок, по умолчанию X-CSRF-Token
+	CookieName     string   // Cookie токена в режиме double-submit, по умолчанию _csrf
+	TrustedOrigins []string // Хосты, кроме хоста запроса, с которых разрешены запросы, например app.example.com
+	DoubleSubmit   bool     // Хранить токен в cookie даже при включенных сессиях
+}
+
+const (
+	// csrfTokenLen - длина секрета токена в байтах
+	csrfTokenLen = 32
+	// csrfKey - ключ секрета в сессии
+	csrfKey = "_csrf"
+)
+
+var (
+	errCSRFToken  = &HTTPError{Code: http.StatusForbidden, Message: "Invalid CSRF token"}
+	errCSRFOrigin = &HTTPError{Code: http.StatusForbidden, Message: "Cross-origin request denied"}
+
+	defaultCSRF = NewCSRF()
+)
+
+func NewCSRF() *CSRF {
+	return &CSRF{
+		FieldName:  "_csrf",
+		HeaderName: "X-CSRF-Token",
+		CookieName: "_csrf",
+	}
+}
+
+func (m *CSRF) Handler(ctx *Context) bool {
+	ctx.csrf = m
+
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	if ctx.options != nil && ctx.options.CSRFExempt {
+		return true
+	}
+
+	if !m.checkOrigin(ctx.Request) {
+		app.handleError(ctx, errCSRFOrigin)
+		return false
+	}
+
+	token := ctx.Request.Header.Get(m.HeaderName)
+	if token == "" {
+		token = ctx.Request.PostForm.Get(m.FieldName)
+	}
+
+	secret := m.secret(ctx)
+	sent := unmaskCSRFToken(token)
+	if secret == nil || subtle.ConstantTimeCompare(secret, sent) != 1 {
+		app.handleError(ctx, errCSRFToken)
+		return false
+	}
+
+	return true
+}
+
+// checkOrigin сравнивает хост из Origin, а без него из Referer, с хостом запроса.
+// Запрос без обоих заголовков пропускается, его защищает токен.
+func (m *CSRF) checkOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, host := range m.TrustedOrigins {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// secret возвращает сохраненный секрет токена, nil - секрета нет
+func (m *CSRF) secret(ctx *Context) []byte {
+	if ctx.csrfSecret != nil {
+		return ctx.csrfSecret
+	}
+
+	var stored string
+	if app.sessions != nil && !m.DoubleSubmit {
+		sess, err := ctx.Session()
+		if err != nil {
+			LOGGER.Error(err)
+			return nil
+		}
+		stored, _ = sess.Get(csrfKey).(string)
+	} else {
+		stored = ctx.GetCookie(m.CookieName)
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(stored)
+	if err != nil || len(secret) != csrfTokenLen {
+		return nil
+	}
+
+	ctx.csrfSecret = secret
+	return secret
+}
+
+// newSecret создает секрет токена и сохраняет его в сессии или cookie
+func (m *CSRF) newSecret(ctx *Context) ([]byte, error) {
+	secret := make([]byte, csrfTokenLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(secret)
+
+	if app.sessions != nil && !m.DoubleSubmit {
+		sess, err := ctx.Session()
+		if err != nil {
+			return nil, err
+		}
+		sess.Set(csrfKey, value)
+	} else {
+		// Cookie доступна JS, чтобы клиент мог передать токен заголовком, даже если
+		// приложение по умолчанию задает HttpOnly
+		defaults := app.cookieDefaults
+		defaults.HttpOnly = false
+		ctx.setCookie(&http.Cookie{Name: m.CookieName, Value: value, Path: "/"}, defaults)
+	}
+
+	ctx.csrfSecret = secret
+	return secret, nil
+}
+
+// CSRFToken возвращает токен для формы или заголовка X-CSRF-Token. Токен маскируется
+// случайным ключом и отличается в каждом ответе, секрет создается при первом обращении.
+func (c *Context) CSRFToken() (string, error) {
+	m := c.csrf
+	if m == nil {
+		m = defaultCSRF
+	}
+
+	secret := m.secret(c)
+	if secret == nil {
+		var err error
+		if secret, err = m.newSecret(c); err != nil {
+			return "", err
+		}
+	}
+
+	return maskCSRFToken(secret)
+}
+
+// csrfField возвращает скрытое поле формы с токеном для шаблонов
+func (c *Context) csrfField() (template.HTML, error) {
+	m := c.csrf
+	if m == nil {
+		m = defaultCSRF
+	}
+
+	token, err := c.CSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(m.FieldName) + `" value="` + token + `">`), nil
+}
+
+// templateCSRFToken и templateCSRFField - функции шаблонов csrfToken и csrfField,
+// ctx - контекст выполнения шаблона (см. templateSet)
+func templateCSRFToken(ctx *Context) (string, error) {
+	if ctx == nil {
+		return "", nil
+	}
+	return ctx.CSRFToken()
+}
+
+func templateCSRFField(ctx *Context) (template.HTML, error) {
+	if ctx == nil {
+		return "", nil
+	}
+	return ctx.csrfField()
+}
+
+// maskCSRFToken возвращает ключ и секрет, сложенный с ключом по XOR, в base64url
+func maskCSRFToken(secret []byte) (string, error) {
+	buf := make([]byte, 2*len(secret))
+	if _, err := rand.Read(buf[:len(secret)]); err != nil {
+		return "", err
+	}
+
+	for i := range secret {
+		buf[len(secret)+i] = buf[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// unmaskCSRFToken восстанавливает секрет из маскированного токена.
+// Немаскированный секрет (значение cookie в режиме double-submit) возвращается как есть.
+func unmaskCSRFToken(token string) []byte {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil
+	}
+
+	switch len(buf) {
+	case csrfTokenLen:
+		return buf
+	case 2 * csrfTokenLen:
+		secret := buf[csrfTokenLen:]
+		for i := range secret {
+			secret[i] ^= buf[i]
+		}
+		return secret
+	}
+	return nil
+}
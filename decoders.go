@@ -0,0 +1,450 @@
+package webgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+type (
+	// Decoder разбирает тело запроса. Для Context.Data значение разбирается в *interface{},
+	// для Context.ValidateSchema - в указатель на структуру. Декодер может не поддерживать
+	// структуры и оставлять их без изменений: поля с тегами form все равно заполняются
+	// из значения, разобранного в *interface{}.
+	Decoder interface {
+		Decode(r io.Reader, v interface{}) error
+	}
+
+	// DecoderFunc позволяет использовать функцию как Decoder
+	DecoderFunc func(r io.Reader, v interface{}) error
+
+	// StreamDecoder - декодер, которому тело передается без чтения в память.
+	// Действие читает тело из Request.Body само либо через Context.Data.
+	StreamDecoder interface {
+		Decoder
+		Stream() bool
+	}
+
+	jsonDecoder        struct{}
+	xmlDecoder         struct{}
+	msgpackDecoder     struct{}
+	textDecoder        struct{}
+	octetStreamDecoder struct{}
+)
+
+const (
+	CT_XML          = "application/xml"
+	CT_MSGPACK      = "application/msgpack"
+	CT_TEXT         = "text/plain"
+	CT_OCTET_STREAM = "application/octet-stream"
+)
+
+// Декодеры по типу содержимого. Для типов с суффиксом (application/problem+json,
+// application/atom+xml) используется декодер application/json и application/xml.
+var decoders = map[string]Decoder{
+	CT_JSON:                 jsonDecoder{},
+	CT_XML:                  xmlDecoder{},
+	"text/xml":              xmlDecoder{},
+	CT_MSGPACK:              msgpackDecoder{},
+	"application/x-msgpack": msgpackDecoder{},
+	CT_TEXT:                 textDecoder{},
+	CT_OCTET_STREAM:         octetStreamDecoder{},
+}
+
+// RegisterDecoder добавляет или заменяет декодер для типа содержимого.
+// Декодеры нужно регистрировать до запуска приложения.
+func RegisterDecoder(mediaType string, decoder Decoder) {
+	decoders[strings.ToLower(mediaType)] = decoder
+}
+
+// lookupDecoder ищет декодер по типу содержимого, затем по суффиксу +json, +xml и т.д.
+func lookupDecoder(mediaType string) Decoder {
+	if decoder, ok := decoders[mediaType]; ok {
+		return decoder
+	}
+
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		return decoders["application/"+mediaType[i+1:]]
+	}
+	return nil
+}
+
+func isStream(decoder Decoder) bool {
+	stream, ok := decoder.(StreamDecoder)
+	return ok && stream.Stream()
+}
+
+func (f DecoderFunc) Decode(r io.Reader, v interface{}) error {
+	return f(r, v)
+}
+
+// Числа в interface{} разбираются как json.Number без потери точности
+func (jsonDecoder) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("json: unexpected data after top-level value")
+	}
+	return nil
+}
+
+// В interface{} документ XML разбирается как map содержимого корневого элемента:
+// вложенные элементы и атрибуты по имени, повторяющиеся элементы - списком,
+// текст элемента без вложенных - строкой.
+func (xmlDecoder) Decode(r io.Reader, v interface{}) error {
+	dec := xml.NewDecoder(r)
+
+	target, ok := v.(*interface{})
+	if !ok {
+		return dec.Decode(v)
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			value, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := value.(string); ok {
+				value = map[string]interface{}{start.Name.Local: value}
+			}
+			*target = value
+			return nil
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	node := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		node[attr.Name.Local] = attr.Value
+	}
+
+	var text bytes.Buffer
+	nested := len(start.Attr) > 0
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			nested = true
+
+			name := t.Name.Local
+			switch prev := node[name].(type) {
+			case nil:
+				node[name] = child
+			case []interface{}:
+				node[name] = append(prev, child)
+			default:
+				node[name] = []interface{}{prev, child}
+			}
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			if !nested {
+				return text.String(), nil
+			}
+			if str := strings.TrimSpace(text.String()); str != "" {
+				node["#text"] = str
+			}
+			return node, nil
+		}
+	}
+}
+
+// MessagePack разбирается в значения тех же типов, что и JSON; в структуры -
+// через JSON, поэтому поля структуры сопоставляются по тегам json
+func (msgpackDecoder) Decode(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	reader := &msgpackReader{data: data}
+	value, err := reader.value(0)
+	if err != nil {
+		return err
+	}
+	if reader.pos != len(data) {
+		return errors.New("msgpack: unexpected data after top-level value")
+	}
+
+	if target, ok := v.(*interface{}); ok {
+		*target = value
+		return nil
+	}
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// Текст разбирается только в *string, *[]byte или *interface{}
+func (textDecoder) Decode(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	switch target := v.(type) {
+	case *string:
+		*target = string(data)
+	case *[]byte:
+		*target = data
+	case *interface{}:
+		*target = string(data)
+	default:
+		return fmt.Errorf("Can't decode %s into %T", CT_TEXT, v)
+	}
+	return nil
+}
+
+// Тело application/octet-stream не читается заранее, его можно получить как io.Reader
+func (octetStreamDecoder) Decode(r io.Reader, v interface{}) (err error) {
+	switch target := v.(type) {
+	case *io.Reader:
+		*target = r
+	case *[]byte:
+		*target, err = ioutil.ReadAll(r)
+	case *interface{}:
+		*target, err = ioutil.ReadAll(r)
+	default:
+		err = fmt.Errorf("Can't decode %s into %T", CT_OCTET_STREAM, v)
+	}
+	return
+}
+
+func (octetStreamDecoder) Stream() bool {
+	return true
+}
+
+// msgpackReader разбирает MessagePack в nil, bool, int64, uint64, float64,
+// string, []byte, []interface{} и map[string]interface{}
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// Ограничение вложенности защищает от переполнения стека
+const msgpackMaxDepth = 100
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func (m *msgpackReader) value(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errors.New("msgpack: max depth exceeded")
+	}
+
+	b, err := m.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return m.mapValue(int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return m.array(int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return m.str(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := m.length(b - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return m.bytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := m.length(b - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return m.ext(n)
+	case 0xca:
+		buf, err := m.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 0xcb:
+		buf, err := m.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		val, err := m.uint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if val <= math.MaxInt64 {
+			return int64(val), nil
+		}
+		return val, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		val, err := m.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Расширение знака
+		shift := uint(64 - size*8)
+		return int64(val<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return m.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := m.length(b - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return m.str(n)
+	case 0xdc, 0xdd:
+		n, err := m.length(b - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return m.array(n, depth)
+	case 0xde, 0xdf:
+		n, err := m.length(b - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return m.mapValue(n, depth)
+	}
+
+	return nil, fmt.Errorf("msgpack: unknown format 0x%x", b)
+}
+
+func (m *msgpackReader) byte() (byte, error) {
+	if m.pos >= len(m.data) {
+		return 0, errMsgpackShort
+	}
+	m.pos++
+	return m.data[m.pos-1], nil
+}
+
+func (m *msgpackReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(m.data)-m.pos {
+		return nil, errMsgpackShort
+	}
+	m.pos += n
+	return m.data[m.pos-n : m.pos], nil
+}
+
+func (m *msgpackReader) uint(size int) (uint64, error) {
+	buf, err := m.bytes(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var val uint64
+	for _, b := range buf {
+		val = val<<8 | uint64(b)
+	}
+	return val, nil
+}
+
+// length читает длину размером 1, 2 или 4 байта (kind 0, 1, 2)
+func (m *msgpackReader) length(kind byte) (int, error) {
+	val, err := m.uint(1 << kind)
+	return int(val), err
+}
+
+func (m *msgpackReader) str(n int) (interface{}, error) {
+	buf, err := m.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
+
+// ext возвращает данные расширения без типа
+func (m *msgpackReader) ext(n int) (interface{}, error) {
+	if _, err := m.byte(); err != nil {
+		return nil, err
+	}
+	return m.bytes(n)
+}
+
+func (m *msgpackReader) array(n int, depth int) (interface{}, error) {
+	// Каждый элемент занимает хотя бы байт
+	if n > len(m.data)-m.pos {
+		return nil, errMsgpackShort
+	}
+
+	list := make([]interface{}, n)
+	for i := range list {
+		val, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = val
+	}
+	return list, nil
+}
+
+func (m *msgpackReader) mapValue(n int, depth int) (interface{}, error) {
+	if n > len(m.data)-m.pos {
+		return nil, errMsgpackShort
+	}
+
+	res := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if str, ok := key.(string); ok {
+			res[str] = val
+		} else {
+			res[fmt.Sprint(key)] = val
+		}
+	}
+	return res, nil
+}
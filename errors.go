@@ -0,0 +1,212 @@
+package webgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IntelliQru/i18n"
+)
+
+type (
+	// HTTPError - ошибка с кодом ответа. Действие может вернуть ее, чтобы
+	// ответить клиенту сообщением и деталями, например ошибками валидации.
+	HTTPError struct {
+		Code    int
+		Message string
+		Details interface{}
+		Err     error // Исходная ошибка, в ответ не попадает
+	}
+
+	// ErrorHandlerFunc формирует ответ на ошибку действия, маршрутизации или разбора запроса
+	ErrorHandlerFunc func(ctx *Context, err error)
+
+	// ErrorPage - модель шаблона страницы ошибки. Message переведено на язык запроса,
+	// T позволяет переводить в шаблоне остальные строки: {{call .T "id"}}.
+	ErrorPage struct {
+		Code    int
+		Message string
+		Path    string
+		Details interface{}
+		Lang    string
+		T       i18n.TFuncHandler
+	}
+)
+
+func NewHTTPError(code int, message string) *HTTPError {
+	return &HTTPError{Code: code, Message: message}
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Code)
+	}
+
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %s", e.Code, msg, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, msg)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler задает обработчик ошибок приложения, по умолчанию DefaultErrorHandler
+func (a *App) ErrorHandler(handler ErrorHandlerFunc) {
+	a.errorHandler = handler
+}
+
+// SetErrorTemplate задает шаблон страницы ошибки, модель шаблона - ErrorPage.
+// Используется для кодов, у которых нет собственной страницы SetErrorPage.
+func (a *App) SetErrorTemplate(tpl string) {
+	a.errorTemplate = tpl
+}
+
+// SetErrorPage задает шаблон страницы для кода ответа, например SetErrorPage(404, "errors/404")
+func (a *App) SetErrorPage(code int, tpl string) {
+	if tpl == "" {
+		delete(a.errorPages, code)
+		return
+	}
+	a.errorPages[code] = tpl
+}
+
+// errorPageTemplate возвращает шаблон страницы ошибки для кода ответа
+func (a *App) errorPageTemplate(code int) string {
+	if tpl, ok := a.errorPages[code]; ok {
+		return tpl
+	}
+	return a.errorTemplate
+}
+
+func ErrorHandler(handler ErrorHandlerFunc) {
+	app.ErrorHandler(handler)
+}
+func SetErrorTemplate(tpl string) {
+	app.SetErrorTemplate(tpl)
+}
+func SetErrorPage(code int, tpl string) {
+	app.SetErrorPage(code, tpl)
+}
+
+// handleError передает ошибку обработчику приложения
+func (a *App) handleError(ctx *Context, err error) {
+	if a.errorHandler != nil {
+		a.errorHandler(ctx, err)
+		return
+	}
+	DefaultErrorHandler(ctx, err)
+}
+
+// toHTTPError приводит ошибку к HTTPError. Ошибки без кода считаются внутренними,
+// их текст клиенту не отдается. HTTPError с кодом вне 400-599 получает код code или 500.
+func toHTTPError(err error, code int) *HTTPError {
+	if code < 400 || code > 599 {
+		code = http.StatusInternalServerError
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.Code < 400 || httpErr.Code > 599 {
+			fixed := *httpErr
+			fixed.Code = code
+			return &fixed
+		}
+		return httpErr
+	}
+
+	// Ошибки валидации отдаются с перечнем полей
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return &HTTPError{Code: http.StatusBadRequest, Message: validationErrs.Error(), Details: validationErrs, Err: err}
+	}
+
+	return &HTTPError{Code: code, Err: err}
+}
+
+// DefaultErrorHandler отдает ошибку в формате application/problem+json (RFC 7807),
+// если клиент ожидает JSON, иначе страницу из шаблона ошибок или текст.
+func DefaultErrorHandler(ctx *Context, err error) {
+	httpErr := toHTTPError(err, ctx.code)
+
+	if httpErr.Code >= 500 && httpErr.Err != nil {
+		LOGGER.Error(httpErr)
+	}
+
+	page := ErrorPage{
+		Code:    httpErr.Code,
+		Message: httpErr.Message,
+		Path:    ctx.Request.URL.Path,
+		Details: httpErr.Details,
+		Lang:    ctx.Lang,
+		T:       i18n.Tfunc(ctx.Lang),
+	}
+	if page.Message == "" {
+		page.Message = http.StatusText(page.Code)
+	}
+
+	// Сообщение служит идентификатором перевода
+	page.Message = page.T(page.Message)
+
+	header := ctx.Response.Header()
+	header.Del("Content-Length")
+
+	if acceptsJSON(ctx.Request) {
+		problem := map[string]interface{}{
+			"type":   "about:blank",
+			"title":  http.StatusText(page.Code),
+			"status": page.Code,
+			"detail": page.Message,
+		}
+		if page.Details != nil {
+			problem["details"] = page.Details
+		}
+
+		data, _ := json.Marshal(problem)
+
+		header.Set("Content-Type", "application/problem+json; charset=utf-8")
+		ctx.Response.WriteHeader(page.Code)
+		ctx.Response.Write(data)
+		return
+	}
+
+	if tpl := app.errorPageTemplate(page.Code); tpl != "" {
+		var buf bytes.Buffer
+		err := app.executeTemplate(&buf, ctx, tpl+".html", page)
+		if err == nil {
+			header.Set("Content-Type", "text/html; charset=utf-8")
+			ctx.Response.WriteHeader(page.Code)
+			ctx.Response.Write(buf.Bytes())
+			return
+		}
+		LOGGER.Error(err)
+	}
+
+	http.Error(ctx.Response, page.Message, page.Code)
+}
+
+// acceptsJSON проверяет, что клиент ожидает JSON, а не HTML
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return strings.HasPrefix(r.Header.Get("Content-Type"), CT_JSON)
+	}
+
+	jsonPos := strings.Index(accept, "json")
+	htmlPos := strings.Index(accept, "html")
+	return jsonPos >= 0 && (htmlPos < 0 || jsonPos < htmlPos)
+}
+
+// bodyErrorCode возвращает 413 для превышения размера тела запроса, иначе 400
+func bodyErrorCode(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
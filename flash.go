@@ -0,0 +1,126 @@
+package webgo
+
+import (
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"net/http"
+)
+
+// Flash - сообщение для следующего запроса, например "Сохранено" после редиректа
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+const (
+	// flashKey - ключ сессии и имя cookie с сообщениями, если сессии отключены
+	flashKey = "_flash"
+	// flashCookieLifetime - срок жизни cookie с сообщениями в секундах
+	flashCookieLifetime = 300
+)
+
+func init() {
+	gob.Register([]Flash{})
+}
+
+// Flash добавляет сообщение, которое можно прочитать один раз в следующем запросе.
+// Сообщение служит идентификатором перевода и переводится на язык текущего запроса.
+func (c Controller) Flash(kind string, msg string, args ...interface{}) {
+	if c.T != nil {
+		msg = c.T(msg, args...)
+	}
+	c.Ctx.flashes = append(c.Ctx.flashes, Flash{Kind: kind, Message: msg})
+}
+
+// Flashes возвращает сообщения предыдущих запросов и удаляет их, повторный вызов
+// в том же запросе возвращает те же сообщения. В шаблонах - {{range flashes}}.
+func (c *Context) Flashes() []Flash {
+	if c.flashesRead {
+		return c.flashesIn
+	}
+	c.flashesRead = true
+
+	c.flashesIn = c.storedFlashes()
+	if c.flashesIn == nil {
+		return nil
+	}
+
+	if app.sessions != nil {
+		if sess, err := c.Session(); err == nil {
+			sess.Delete(flashKey)
+		}
+	} else {
+		c.DeleteCookie(flashKey, "/", "")
+	}
+	return c.flashesIn
+}
+
+// storedFlashes читает сохраненные сообщения из сессии или cookie
+func (c *Context) storedFlashes() []Flash {
+	if app.sessions != nil {
+		sess, err := c.Session()
+		if err != nil {
+			LOGGER.Error(err)
+			return nil
+		}
+		flashes, _ := sess.Get(flashKey).([]Flash)
+		return flashes
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(c.GetCookie(flashKey))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	var flashes []Flash
+	if json.Unmarshal(data, &flashes) != nil {
+		return nil
+	}
+	return flashes
+}
+
+// saveFlashes сохраняет новые сообщения вместе с непрочитанными, вызывается до сохранения сессии
+func (a *App) saveFlashes(ctx *Context) {
+	if len(ctx.flashes) == 0 {
+		return
+	}
+
+	var flashes []Flash
+	if !ctx.flashesRead {
+		flashes = ctx.storedFlashes()
+	}
+	flashes = append(flashes, ctx.flashes...)
+	ctx.flashes = nil
+
+	if a.sessions != nil {
+		sess, err := ctx.Session()
+		if err != nil {
+			LOGGER.Error(err)
+			return
+		}
+		sess.Set(flashKey, flashes)
+		return
+	}
+
+	data, err := json.Marshal(flashes)
+	if err != nil {
+		LOGGER.Error(err)
+		return
+	}
+	ctx.SetCookieOpts(&http.Cookie{
+		Name:     flashKey,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     "/",
+		MaxAge:   flashCookieLifetime,
+		HttpOnly: true,
+	})
+}
+
+// templateFlashes - функция шаблонов flashes, ctx - контекст выполнения шаблона (см. templateSet)
+func templateFlashes(ctx *Context) []Flash {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Flashes()
+}
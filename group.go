@@ -0,0 +1,139 @@
+package webgo
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Group - группа маршрутов с общим префиксом и общими настройками.
+// Незаполненные поля RouteOptions маршрутов группы берутся из настроек группы.
+type Group struct {
+	prefix string
+	opts   RouteOptions
+	router *Router
+	err    *error
+}
+
+func (g *Group) Get(url string, opts RouteOptions) {
+	g.Add(http.MethodGet, url, opts)
+}
+func (g *Group) Post(url string, opts RouteOptions) {
+	g.Add(http.MethodPost, url, opts)
+}
+func (g *Group) Put(url string, opts RouteOptions) {
+	g.Add(http.MethodPut, url, opts)
+}
+func (g *Group) Delete(url string, opts RouteOptions) {
+	g.Add(http.MethodDelete, url, opts)
+}
+func (g *Group) Options(url string, opts RouteOptions) {
+	g.Add(http.MethodOptions, url, opts)
+}
+func (g *Group) Patch(url string, opts RouteOptions) {
+	g.Add(http.MethodPatch, url, opts)
+}
+func (g *Group) Head(url string, opts RouteOptions) {
+	g.Add(http.MethodHead, url, opts)
+}
+
+// Add добавляет маршрут в группу. Первая ошибка возвращается из Router.Group.
+func (g *Group) Add(method, url string, opts RouteOptions) {
+	if *g.err != nil {
+		return
+	}
+
+	opts.inherit(&g.opts)
+	*g.err = g.router.Add(method, joinPath(g.prefix, url), &opts)
+}
+
+// Group создает вложенную группу, префиксы и настройки групп объединяются
+func (g *Group) Group(prefix string, opts RouteOptions, fn func(g *Group)) {
+	opts.inherit(&g.opts)
+
+	fn(&Group{
+		prefix: joinPath(g.prefix, prefix),
+		opts:   opts,
+		router: g.router,
+		err:    g.err,
+	})
+}
+
+func (r *Router) Group(prefix string, opts RouteOptions, fn func(g *Group)) (err error) {
+	fn(&Group{
+		prefix: joinPath("", prefix),
+		opts:   opts,
+		router: r,
+		err:    &err,
+	})
+	return
+}
+
+func (a *App) Group(prefix string, opts RouteOptions, fn func(g *Group)) {
+	err := a.router.Group(prefix, opts, fn)
+	if err != nil {
+		LOGGER.Fatal(err)
+	}
+}
+
+// AddGroup - аналог App.Group для приложения по умолчанию
+func AddGroup(prefix string, opts RouteOptions, fn func(g *Group)) {
+	app.Group(prefix, opts, fn)
+}
+
+// inherit заполняет пустые поля значениями по умолчанию группы.
+// Имя маршрута и действие не наследуются.
+func (o *RouteOptions) inherit(defaults *RouteOptions) {
+	if o.MiddlewareGroup == "" {
+		o.MiddlewareGroup = defaults.MiddlewareGroup
+	}
+	if o.Host == "" {
+		o.Host = defaults.Host
+	}
+	if o.Controller == nil {
+		o.Controller = defaults.Controller
+	}
+	if o.ContentType == "" {
+		o.ContentType = defaults.ContentType
+	}
+	if o.BodyLength == 0 {
+		o.BodyLength = defaults.BodyLength
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaults.Timeout
+	}
+	if defaults.I18n {
+		o.I18n = true
+	}
+	if defaults.StrictJSON {
+		o.StrictJSON = true
+	}
+	if defaults.StreamMultipart {
+		o.StreamMultipart = true
+	}
+	if defaults.CSRFExempt {
+		o.CSRFExempt = true
+	}
+	if o.MaxFileSize == 0 {
+		o.MaxFileSize = defaults.MaxFileSize
+	}
+	if o.MaxFiles == 0 {
+		o.MaxFiles = defaults.MaxFiles
+	}
+}
+
+// joinPath склеивает префикс группы и путь маршрута
+func joinPath(prefix, path string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	switch {
+	case path == "" || path == "/":
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	case path[0] != '/':
+		return prefix + "/" + path
+	}
+
+	return prefix + path
+}
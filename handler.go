@@ -0,0 +1,135 @@
+package webgo
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type paramsKey struct{}
+
+// mountMethods - методы, которые перенаправляются в обработчик, подключенный через Mount
+var mountMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// RequestParams возвращает параметры маршрута для обработчиков net/http
+func RequestParams(r *http.Request) Params {
+	params, _ := r.Context().Value(paramsKey{}).(Params)
+	return params
+}
+
+// Handle регистрирует обработчик net/http для метода и пути
+func (a *App) Handle(method, url string, handler http.Handler) {
+	a.addRoute(method, url, &RouteOptions{Handler: handler})
+}
+
+func (a *App) HandleFunc(method, url string, handler func(http.ResponseWriter, *http.Request)) {
+	a.Handle(method, url, http.HandlerFunc(handler))
+}
+
+// Mount передает обработчику все запросы к prefix и вложенным путям, prefix из пути удаляется
+func (a *App) Mount(prefix string, handler http.Handler) {
+	routes := mountRoutes(prefix, handler)
+
+	for _, method := range mountMethods {
+		for i := range routes {
+			opts := routes[i].RouteOptions
+			a.addRoute(method, routes[i].url, &opts)
+		}
+	}
+}
+
+func (g *Group) Handle(method, url string, handler http.Handler) {
+	g.Add(method, url, RouteOptions{Handler: handler})
+}
+
+func (g *Group) HandleFunc(method, url string, handler func(http.ResponseWriter, *http.Request)) {
+	g.Handle(method, url, http.HandlerFunc(handler))
+}
+
+func (g *Group) Mount(prefix string, handler http.Handler) {
+	routes := mountRoutes(joinPath(g.prefix, prefix), handler)
+
+	for _, method := range mountMethods {
+		for i := range routes {
+			if *g.err != nil {
+				return
+			}
+
+			// Префикс группы уже учтен в пути
+			opts := routes[i].RouteOptions
+			opts.inherit(&g.opts)
+			*g.err = g.router.Add(method, routes[i].url, &opts)
+		}
+	}
+}
+
+func Handle(method, url string, handler http.Handler) {
+	app.Handle(method, url, handler)
+}
+func HandleFunc(method, url string, handler func(http.ResponseWriter, *http.Request)) {
+	app.HandleFunc(method, url, handler)
+}
+func Mount(prefix string, handler http.Handler) {
+	app.Mount(prefix, handler)
+}
+
+type mountRoute struct {
+	RouteOptions
+	url string
+}
+
+func mountRoutes(prefix string, handler http.Handler) []mountRoute {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return []mountRoute{{RouteOptions{Handler: stripPrefix(handler)}, "/*path"}}
+	}
+
+	handler = stripPrefix(handler)
+
+	return []mountRoute{
+		{RouteOptions{Handler: handler}, prefix},
+		{RouteOptions{Handler: handler}, prefix + "/*path"},
+	}
+}
+
+// stripPrefix - аналог http.StripPrefix, который оставляет "/" вместо пустого пути.
+// Путь берется из параметра *path маршрута, то есть после очистки пути и без учета
+// регистра префикса, так же, как его сопоставил маршрутизатор.
+func stripPrefix(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+
+		r2.URL.Path = "/" + RequestParams(r).Get("path")
+		r2.URL.RawPath = ""
+
+		handler.ServeHTTP(w, r2)
+	})
+}
+
+// serveHandler выполняет маршрут с обработчиком net/http после цепочки middleware
+func (a *App) serveHandler(w http.ResponseWriter, r *http.Request, route *Match) {
+	ctx := newContext(w, r, route)
+	defer a.recoverPanic(ctx, route)
+
+	sw := a.wrapResponse(ctx)
+	if !a.definitions.Run(route.Options.MiddlewareGroup, ctx) {
+		sw.finish()
+		return
+	}
+	a.commitSession(ctx)
+
+	r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, route.Params))
+	route.Options.Handler.ServeHTTP(w, r)
+}
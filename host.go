@@ -0,0 +1,95 @@
+package webgo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hostTable - маршруты, привязанные к шаблону хоста, например :tenant.example.com
+type hostTable struct {
+	pattern string
+	labels  []string
+	keys    []string
+	trees   map[string]*node
+}
+
+func newHostTable(pattern string) (*hostTable, error) {
+	table := &hostTable{
+		pattern: pattern,
+		labels:  strings.Split(strings.ToLower(pattern), "."),
+		trees:   make(map[string]*node),
+	}
+
+	for _, label := range table.labels {
+		if label == "" {
+			return nil, fmt.Errorf("Invalid host pattern: '%s'.", pattern)
+		}
+
+		if label[0] == ':' {
+			if !_RE_HOST_KEY.MatchString(label) {
+				return nil, fmt.Errorf("Invalid host param '%s' in '%s'.", label, pattern)
+			}
+			table.keys = append(table.keys, label[1:])
+		}
+	}
+
+	return table, nil
+}
+
+// match сопоставляет хост с шаблоном, значения параметров дописываются в values
+func (t *hostTable) match(host string, values []string) ([]string, bool) {
+	for i, label := range t.labels {
+		end := strings.IndexByte(host, '.')
+		if end < 0 {
+			end = len(host)
+		}
+
+		if (end == len(host)) != (i == len(t.labels)-1) || end == 0 {
+			return values, false
+		}
+
+		if label[0] == ':' {
+			values = append(values, host[:end])
+		} else if !strings.EqualFold(label, host[:end]) {
+			return values, false
+		}
+
+		if end < len(host) {
+			host = host[end+1:]
+		}
+	}
+
+	return values, true
+}
+
+// hostTable возвращает таблицу маршрутов для шаблона хоста, создавая ее при необходимости.
+// Шаблоны без параметров проверяются раньше шаблонов с параметрами.
+func (r *Router) hostTable(pattern string) (*hostTable, error) {
+	for _, table := range r.hosts {
+		if strings.EqualFold(table.pattern, pattern) {
+			return table, nil
+		}
+	}
+
+	table, err := newHostTable(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	r.hosts = append(r.hosts, table)
+	sort.SliceStable(r.hosts, func(i, j int) bool {
+		return len(r.hosts[i].keys) < len(r.hosts[j].keys)
+	})
+
+	return table, nil
+}
+
+// stripPort отрезает порт от значения заголовка Host
+func stripPort(host string) string {
+	i := strings.LastIndexByte(host, ':')
+	if i > strings.LastIndexByte(host, ']') {
+		return host[:i]
+	}
+	return host
+}
@@ -0,0 +1,186 @@
+package webgo
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+)
+
+// Part - часть multipart-запроса. Чтение файла прерывается ошибкой 413,
+// если он больше RouteOptions.MaxFileSize.
+type Part struct {
+	*multipart.Part
+	limit int64
+	read  int64
+}
+
+var (
+	errFileTooLarge = &HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "File too large"}
+	errTooManyFiles = &HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "Too many files"}
+)
+
+func (p *Part) Read(data []byte) (n int, err error) {
+	n, err = p.Part.Read(data)
+	p.read += int64(n)
+
+	if p.limit > 0 && p.read > p.limit {
+		return n, errFileTooLarge
+	}
+	return
+}
+
+// NextPart возвращает следующую часть multipart-запроса, io.EOF - частей больше нет.
+// Используется действиями маршрутов с RouteOptions.StreamMultipart: тело не разбирается
+// заранее, и файлы можно обрабатывать по мере получения, не сохраняя на диск.
+// Ограничения MaxFiles и MaxFileSize маршрута действуют и здесь.
+func (c *Context) NextPart() (*Part, error) {
+	var opts RouteOptions
+	if c.options != nil {
+		opts = *c.options
+	}
+
+	if c.multipart == nil {
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		c.multipart = reader
+	}
+
+	part, err := c.multipart.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	if part.FileName() == "" {
+		return &Part{Part: part}, nil
+	}
+
+	c.fileCount++
+	if opts.MaxFiles > 0 && c.fileCount > opts.MaxFiles {
+		part.Close()
+		return nil, errTooManyFiles
+	}
+
+	return &Part{Part: part, limit: opts.MaxFileSize}, nil
+}
+
+// parseMultipart читает multipart-запрос: значения полей попадают в Request.Form и
+// Request.MultipartForm.Value, файлы записываются один раз во временный каталог системы
+// и попадают в Files и Request.MultipartForm.File, поэтому работают Request.FormFile и
+// FileHeader.Open. Временные файлы удаляются после запроса, в том числе при ошибке.
+func parseMultipart(ctx *Context) (errorCode int, err error) {
+	if ctx.Request.PostForm == nil {
+		ctx.Request.PostForm = make(map[string][]string)
+	}
+
+	form := &multipart.Form{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*multipart.FileHeader),
+	}
+	// Форма задается и при ошибке, чтобы RemoveAll удалил уже сохраненные файлы
+	defer func() {
+		ctx.Request.MultipartForm = form
+	}()
+
+	for {
+		var part *Part
+		part, err = ctx.NextPart()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return multipartErrorCode(err), err
+		}
+
+		if part.FileName() == "" {
+			var data []byte
+			data, err = ioutil.ReadAll(part)
+			part.Close()
+			if err != nil {
+				return multipartErrorCode(err), err
+			}
+
+			ctx.Request.PostForm.Add(part.FormName(), string(data))
+			ctx.Request.Form.Add(part.FormName(), string(data))
+			form.Value[part.FormName()] = append(form.Value[part.FormName()], string(data))
+			continue
+		}
+
+		var fh *multipart.FileHeader
+		fh, err = ctx.saveFile(part)
+		part.Close()
+		if err != nil {
+			return multipartErrorCode(err), err
+		}
+		form.File[part.FormName()] = append(form.File[part.FormName()], fh)
+	}
+}
+
+// saveFile записывает файл во временный каталог системы. Часть передается
+// multipart.Reader.ReadForm, чтобы получить обычный FileHeader без повторного копирования:
+// при отрицательном maxMemory ReadForm сразу пишет файл на диск. Недописанный при ошибке
+// файл ReadForm удаляет сам.
+func (c *Context) saveFile(part *Part) (*multipart.FileHeader, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		w, err := writer.CreatePart(part.Header)
+		if err == nil {
+			_, err = io.Copy(w, part)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	form, err := multipart.NewReader(pr, writer.Boundary()).ReadForm(-1)
+
+	// Запись в канал прерывается, если ReadForm завершился раньше
+	pr.Close()
+	<-done
+
+	if err != nil {
+		return nil, err
+	}
+
+	fhs := form.File[part.FormName()]
+	if len(fhs) != 1 {
+		form.RemoveAll()
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Err: errors.New("multipart: file is not saved")}
+	}
+	fh := fhs[0]
+
+	// Путь временного файла FileHeader не экспортирует, его возвращает открытый файл
+	f, err := fh.Open()
+	if err != nil {
+		form.RemoveAll()
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Err: err}
+	}
+	defer f.Close()
+
+	file, ok := f.(*os.File)
+	if !ok {
+		form.RemoveAll()
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Err: errors.New("multipart: file is kept in memory")}
+	}
+
+	c.Files = append(c.Files, File{Path: file.Name(), Name: part.FileName(), Size: fh.Size})
+	return fh, nil
+}
+
+func multipartErrorCode(err error) int {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return bodyErrorCode(err)
+}
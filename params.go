@@ -0,0 +1,126 @@
+package webgo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateLayout - формат значений ограничения <date> и Params.Time по умолчанию
+const DateLayout = "2006-01-02"
+
+var _RE_UUID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (p Params) Get(key string) string {
+	return p[key]
+}
+
+func (p Params) Int(key string) (int, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, p.invalid(key, val, "Integer")
+	}
+	return res, nil
+}
+
+func (p Params) Int64(key string) (int64, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, p.invalid(key, val, "Int64")
+	}
+	return res, nil
+}
+
+func (p Params) Uint64(key string) (uint64, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, p.invalid(key, val, "Uint64")
+	}
+	return res, nil
+}
+
+func (p Params) Float64(key string) (float64, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, p.invalid(key, val, "Float64")
+	}
+	return res, nil
+}
+
+func (p Params) Bool(key string) (bool, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, p.invalid(key, val, "Bool")
+	}
+	return res, nil
+}
+
+// UUID возвращает UUID в каноническом виде (нижний регистр)
+func (p Params) UUID(key string) (string, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !_RE_UUID.MatchString(val) {
+		return "", p.invalid(key, val, "UUID")
+	}
+	return strings.ToLower(val), nil
+}
+
+// Time разбирает значение параметра по layout, пустой layout означает DateLayout
+func (p Params) Time(key string, layout string) (time.Time, error) {
+	val, err := p.value(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if layout == "" {
+		layout = DateLayout
+	}
+
+	res, err := time.Parse(layout, val)
+	if err != nil {
+		return time.Time{}, p.invalid(key, val, "Time ("+layout+")")
+	}
+	return res, nil
+}
+
+func (p Params) value(key string) (string, error) {
+	val, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("Not found param '%s'", key)
+	}
+	return val, nil
+}
+
+func (p Params) invalid(key, val, kind string) error {
+	return fmt.Errorf("Invalid value '%s' for param '%s', must be %s", val, key, kind)
+}
@@ -0,0 +1,108 @@
+package webgo
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SlashPolicy - обработка завершающего слеша в пути запроса
+type SlashPolicy int
+
+const (
+	// SlashRedirect - перенаправление 308 на вариант пути, для которого есть маршрут
+	SlashRedirect SlashPolicy = iota
+	// SlashBoth - оба варианта пути обслуживаются одним маршрутом
+	SlashBoth
+	// SlashStrict - путь должен точно совпадать с шаблоном маршрута
+	SlashStrict
+)
+
+var slashPolicies = map[string]SlashPolicy{
+	"redirect": SlashRedirect,
+	"both":     SlashBoth,
+	"strict":   SlashStrict,
+}
+
+func (a *App) SetTrailingSlash(policy SlashPolicy) {
+	a.trailingSlash = policy
+	a.router.StrictSlash = policy != SlashBoth
+}
+
+// SetCleanPath включает схлопывание // и разрешение . и .. в пути запроса
+func (a *App) SetCleanPath(enabled bool) {
+	a.cleanPath = enabled
+}
+
+// SetCaseInsensitive включает сопоставление статических частей пути без учета регистра
+func (a *App) SetCaseInsensitive(enabled bool) {
+	a.router.CaseInsensitive = enabled
+}
+
+func SetTrailingSlash(policy SlashPolicy) {
+	app.SetTrailingSlash(policy)
+}
+func SetCleanPath(enabled bool) {
+	app.SetCleanPath(enabled)
+}
+func SetCaseInsensitive(enabled bool) {
+	app.SetCaseInsensitive(enabled)
+}
+
+// resolvePath применяет очистку пути. redirect означает, что клиента нужно
+// перенаправить на возвращенный путь, иначе очищенный путь используется как есть.
+func (a *App) resolvePath(host, urlPath string) (result string, redirect bool) {
+	result = urlPath
+	if a.cleanPath {
+		result = cleanPath(urlPath)
+	}
+
+	if a.trailingSlash != SlashRedirect || result == urlPath {
+		return
+	}
+
+	// Очищенному пути может понадобиться и другой завершающий слеш: одно перенаправление вместо двух
+	if len(a.router.AllowedHost(host, result)) == 0 {
+		if alt, ok := a.slashAlternative(host, result); ok {
+			result = alt
+		}
+	}
+	return result, true
+}
+
+// slashAlternative возвращает вариант пути с другим завершающим слешем, если для него есть маршрут
+// и действует политика SlashRedirect. Вызывается, только когда для самого пути маршрута нет.
+func (a *App) slashAlternative(host, urlPath string) (string, bool) {
+	if a.trailingSlash != SlashRedirect || len(urlPath) <= 1 {
+		return "", false
+	}
+
+	alt := urlPath + "/"
+	if strings.HasSuffix(urlPath, "/") {
+		alt = urlPath[:len(urlPath)-1]
+	}
+
+	return alt, len(a.router.AllowedHost(host, alt)) > 0
+}
+
+// redirectPath перенаправляет запрос на другой путь с сохранением метода и строки запроса
+func redirectPath(w http.ResponseWriter, r *http.Request, urlPath string) {
+	u := *r.URL
+	u.Path = urlPath
+	u.RawPath = ""
+
+	http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// cleanPath схлопывает повторяющиеся слеши и разрешает . и .., сохраняя завершающий слеш
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	res := path.Clean("/" + p)
+	if res != "/" && p[len(p)-1] == '/' {
+		res += "/"
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package webgo
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicHandler вызывается после перехвата паники, например для отправки в трекер ошибок
+type PanicHandler func(ctx *Context, err interface{})
+
+// OnPanic задает обработчик перехваченных паник
+func (a *App) OnPanic(handler PanicHandler) {
+	a.panicHandler = handler
+}
+
+func OnPanic(handler PanicHandler) {
+	app.OnPanic(handler)
+}
+
+// recoverPanic перехватывает панику действия, middleware или обработчика, пишет стек в лог,
+// удаляет временные файлы запроса и отдает ответ 500. Вызывается только через defer.
+func (a *App) recoverPanic(ctx *Context, route *Match) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	// Прерывание ответа обрабатывает net/http
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+
+	action := "-"
+	if route != nil {
+		if route.ControllerType != nil {
+			action = route.ControllerType.String() + "." + route.Options.Action
+		} else {
+			action = route.Pattern
+		}
+	}
+
+	LOGGER.Error(fmt.Errorf("Panic in %s (%s %s): %v\n%s", action, ctx.Request.Method, ctx.Request.URL.RequestURI(), p, debug.Stack()))
+	ctx.removeFiles()
+
+	a.notifyPanic(ctx, p)
+	a.handleError(ctx, &HTTPError{Code: http.StatusInternalServerError})
+}
+
+// recoverRequest перехватывает панику вне действия: при поиске маршрута, в обработчике ошибок
+// или повторно поднятую из serveController. Ответ пишется напрямую, без handleError,
+// так как паника могла произойти в нем. Вызывается только через defer.
+func (a *App) recoverRequest(w http.ResponseWriter, r *http.Request) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+
+	LOGGER.Error(fmt.Errorf("Panic (%s %s): %v\n%s", r.Method, r.URL.RequestURI(), p, debug.Stack()))
+
+	a.notifyPanic(newContext(w, r, nil), p)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// notifyPanic вызывает обработчик OnPanic, паника в нем только пишется в лог
+func (a *App) notifyPanic(ctx *Context, p interface{}) {
+	if a.panicHandler == nil {
+		return
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			LOGGER.Error(fmt.Errorf("Panic in panic handler: %v", e))
+		}
+	}()
+	a.panicHandler(ctx, p)
+}
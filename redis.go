@@ -0,0 +1,215 @@
+package webgo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// RedisStore хранит сессии на сервере с протоколом Redis (RESP) под ключами session:<id>.
+	// Соединения переиспользуются, срок жизни сессии задается через SET PX.
+	RedisStore struct {
+		Addr     string
+		Password string
+		DB       int
+		Prefix   string
+		Timeout  time.Duration
+
+		mu   sync.Mutex
+		idle []*redisConn
+	}
+
+	redisConn struct {
+		conn   net.Conn
+		reader *bufio.Reader
+	}
+
+	// redisError - ошибка, которую вернул сервер, соединение после нее остается рабочим
+	redisError string
+)
+
+// redisMaxIdle - количество простаивающих соединений, которые хранит RedisStore
+const redisMaxIdle = 8
+
+func NewRedisStore(addr, password string, db int) *RedisStore {
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+	}
+
+	return &RedisStore{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+		Prefix:   "session:",
+		Timeout:  5 * time.Second,
+	}
+}
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (s *RedisStore) Load(id string) ([]byte, error) {
+	reply, err := s.do("GET", s.Prefix+id)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %T", reply)
+	}
+	return data, nil
+}
+
+func (s *RedisStore) Save(id string, data []byte, lifetime time.Duration) error {
+	ms := int64(lifetime / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+
+	_, err := s.do("SET", s.Prefix+id, string(data), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+func (s *RedisStore) Delete(id string) error {
+	_, err := s.do("DEL", s.Prefix+id)
+	return err
+}
+
+// do выполняет команду. Соединение с сетевой ошибкой закрывается, остальные возвращаются в пул.
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	c, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(s.Timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if len(s.idle) < redisMaxIdle {
+		s.idle = append(s.idle, c)
+		c = nil
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// conn берет соединение из пула либо открывает новое с AUTH и SELECT
+func (s *RedisStore) conn() (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if s.Password != "" {
+		if _, err = c.do(s.Timeout, "AUTH", s.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err = c.do(s.Timeout, "SELECT", strconv.Itoa(s.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRESP(c.reader)
+}
+
+// readRESP читает ответ: string (+), redisError (-), int64 (:), []byte или nil ($), []interface{} (*)
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply line")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readRESP(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+			}
+		}
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("redis: unknown reply type '%c'", line[0])
+}
@@ -2,16 +2,25 @@ package webgo
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"regexp"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 )
 
 type (
 	Router struct {
+		// StrictSlash запрещает сопоставление пути с завершающим слешем маршруту без него
+		StrictSlash bool
+		// CaseInsensitive разрешает сопоставление статических частей пути без учета регистра
+		CaseInsensitive bool
+
 		routes Routes
+		trees  map[string]*node
+		hosts  []*hostTable
+		names  map[string]*Route
 		once   sync.Once
 	}
 	Routes map[string]map[string]Route
@@ -21,6 +30,8 @@ type (
 		Pattern        string
 		ControllerType reflect.Type
 		Options        *RouteOptions
+		segments       []segment
+		action         *action
 	}
 	Params map[string]string
 	Match  struct {
@@ -28,57 +39,174 @@ type (
 		Pattern        string
 		ControllerType reflect.Type
 		Options        *RouteOptions
+		route          *Route
 	}
 	RouteOptions struct {
+		Name            string // Имя маршрута для построения ссылок через URL
+		Host            string // Шаблон хоста, например :tenant.example.com
 		MiddlewareGroup string
 		Controller      ControllerInterface
 		Action          string
-		ContentType     string  //Deprecated
+		Handler         http.Handler // Обработчик net/http вместо Controller и Action
+		ContentType     string       //Deprecated
 		BodyLength      int64
-		Timeout         time.Duration
+		Timeout         time.Duration // Например 5 * time.Second (не меньше 1ms), отрицательное значение отключает ограничение
 		I18n            bool
+		StrictJSON      bool  // Неизвестные поля тела JSON в ValidateSchema считаются ошибкой
+		StreamMultipart bool  // Тело multipart не разбирается, действие читает части через Context.NextPart
+		MaxFileSize     int64 // Ограничение размера одного файла multipart, общий размер ограничивает BodyLength
+		MaxFiles        int   // Ограничение количества файлов multipart
+		CSRFExempt      bool  // Маршрут не проверяется middleware CSRF
 	}
 )
 
+// Match ищет маршрут без учета хоста, только среди маршрутов без RouteOptions.Host
 func (r *Router) Match(method string, url string) (result *Match) {
+	return r.MatchHost(method, "", url)
+}
+
+// MatchHost ищет маршрут с учетом хоста. Маршруты, привязанные к хосту,
+// проверяются раньше маршрутов без привязки, параметры хоста добавляются в Params.
+func (r *Router) MatchHost(method string, host string, url string) (result *Match) {
 	r.internalInit()
 
-	routing, ok := r.routes[method]
+	var buf [maxParams]string
+
+	host = stripPort(host)
+
+	for _, table := range r.hosts {
+		root, ok := table.trees[method]
+		if !ok {
+			continue
+		}
+
+		hostValues, ok := table.match(host, buf[:0])
+		if !ok {
+			continue
+		}
+
+		l, values := r.find(root, url, hostValues)
+		if l == nil {
+			continue
+		}
+
+		params := make(Params, len(values))
+		for i := range values {
+			if i < len(table.keys) {
+				params[table.keys[i]] = values[i]
+			} else {
+				params[l.keys[i-len(table.keys)]] = values[i]
+			}
+		}
+
+		result = l.route.match(params)
+		return
+	}
+
+	root, ok := r.trees[method]
 	if !ok {
 		return
 	}
 
-	// Определем контроллер по прямому вхождению
-	if route, ok := routing[url]; ok {
-		result = &Match{make(Params), route.Pattern, route.ControllerType, route.Options}
+	l, values := r.find(root, url, buf[:0])
+	if l == nil {
 		return
 	}
 
-	for _, route := range routing {
-		if !route.Regex.MatchString(url) {
-			continue
-		}
+	params := make(Params, len(values))
+	for i := range values {
+		params[l.keys[i]] = values[i]
+	}
+
+	result = l.route.match(params)
+	return
+}
+
+// Allowed возвращает отсортированный список методов, для которых есть маршрут по пути url.
+// HEAD добавляется при наличии GET, OPTIONS - всегда, если найден хотя бы один маршрут.
+func (r *Router) Allowed(url string) []string {
+	return r.AllowedHost("", url)
+}
 
-		match := route.Regex.FindAllStringSubmatch(url, -1)[0][1:]
-		params := make(Params)
+// AllowedHost - аналог Allowed с учетом маршрутов, привязанных к хосту
+func (r *Router) AllowedHost(host string, url string) (methods []string) {
+	r.internalInit()
+
+	var buf [maxParams]string
 
-		for i := range match {
-			if len(route.Keys) <= i {
-				break
+	has := func(method string) bool {
+		for _, m := range methods {
+			if m == method {
+				return true
 			}
-			params[route.Keys[i]] = match[i]
 		}
+		return false
+	}
 
-		result = &Match{params, route.Pattern, route.ControllerType, route.Options}
+	collect := func(trees map[string]*node, values []string) {
+		for method, root := range trees {
+			if l, _ := r.find(root, url, values); l != nil && !has(method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+
+	host = stripPort(host)
+
+	for _, table := range r.hosts {
+		if values, ok := table.match(host, buf[:0]); ok {
+			collect(table.trees, values)
+		}
+	}
+	collect(r.trees, buf[:0])
+
+	if len(methods) == 0 {
 		return
 	}
 
+	if has(http.MethodGet) && !has(http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	if !has(http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+
+	sort.Strings(methods)
 	return
 }
 
+// find ищет маршрут в дереве с учетом настроек роутера
+func (r *Router) find(root *node, url string, values []string) (*leaf, []string) {
+	l, vals := root.lookup(url, values, false)
+	if l == nil && r.CaseInsensitive {
+		l, vals = root.lookup(url, values, true)
+	}
+
+	if l == nil && !r.StrictSlash && len(url) > 1 && url[len(url)-1] == '/' {
+		url = url[:len(url)-1]
+
+		l, vals = root.lookup(url, values, false)
+		if l == nil && r.CaseInsensitive {
+			l, vals = root.lookup(url, values, true)
+		}
+	}
+
+	return l, vals
+}
+
+func (route *Route) match(params Params) *Match {
+	return &Match{
+		Params:         params,
+		Pattern:        route.Pattern,
+		ControllerType: route.ControllerType,
+		Options:        route.Options,
+		route:          route,
+	}
+}
+
 var (
-	_RE_KEY_PATTERN = regexp.MustCompile(`:([A-Za-z0-9]+)`)
-	_PATH_REPLACER  = strings.NewReplacer(`/`, `\/`, `.`, `\.`)
+	_RE_KEY_PATTERN = regexp.MustCompile(`([:*])([A-Za-z0-9_]+)(<.+>)?(\?)?`)
+	_RE_HOST_KEY    = regexp.MustCompile(`^:[A-Za-z0-9_]+$`)
 )
 
 func (r *Router) Add(method string, path string, opts *RouteOptions) (err error) {
@@ -91,37 +219,117 @@ func (r *Router) Add(method string, path string, opts *RouteOptions) (err error)
 		r.routes[method] = routing
 	}
 
-	if _, ok := routing[path]; ok {
-		err = fmt.Errorf("Route path already use: '%s'->'%s'.", method, path)
+	// Маршруты разных хостов хранятся под ключом хост+путь
+	routeKey := opts.Host + path
+
+	if _, ok := routing[routeKey]; ok {
+		err = fmt.Errorf("Route path already use: '%s'->'%s'.", method, routeKey)
 		return
 	}
 
-	reflectVal := reflect.ValueOf(opts.Controller)
-	val := reflectVal.MethodByName(opts.Action)
-
-	if !val.IsValid() {
-		err = fmt.Errorf("Not found action for '%s'->'%s': '%s'.", method, path, opts.Action)
+	// Timeout задается в time.Duration, значение вроде Timeout: 5 (5ns) - ошибка единиц
+	if opts.Timeout > 0 && opts.Timeout < time.Millisecond {
+		err = fmt.Errorf("Route timeout is less than 1ms: '%s'->'%s' %s.", method, routeKey, opts.Timeout)
 		return
 	}
 
-	controller := reflect.Indirect(reflectVal).Type()
+	if _, ok := r.names[opts.Name]; ok && opts.Name != "" {
+		err = fmt.Errorf("Route name already use: '%s'.", opts.Name)
+		return
+	}
 
 	/* Добавляем роутер */
-	matches := _RE_KEY_PATTERN.FindAllStringSubmatch(path, -1)
-	keys := make([]string, len(matches))
-	for i := range matches {
-		keys[i] = matches[i][1]
+	segments, err := parsePattern(path)
+	if err != nil {
+		return
 	}
 
-	str := fmt.Sprintf("^%s\\/?$", _PATH_REPLACER.Replace(path))
-	str = _RE_KEY_PATTERN.ReplaceAllString(str, "([^\\/]+)")
+	keys := segmentKeys(segments)
+
+	// Параметры хоста и пути попадают в одни Params, поэтому имена не должны совпадать
+	if opts.Host != "" {
+		table, err := newHostTable(opts.Host)
+		if err != nil {
+			return err
+		}
 
-	regex, err := regexp.Compile(str)
+		for _, key := range keys {
+			for _, hostKey := range table.keys {
+				if key == hostKey {
+					return fmt.Errorf("Route '%s'->'%s': param '%s' is used in host and path.", method, routeKey, key)
+				}
+			}
+		}
+	}
+
+	regex, err := regexp.Compile(patternRegex(segments))
 	if err != nil {
 		return err
 	}
 
-	routing[path] = Route{keys, regex, path, controller, opts}
+	// Для обработчиков net/http контроллер не нужен
+	var controller reflect.Type
+	var action *action
+
+	if opts.Handler == nil {
+		if opts.Controller == nil {
+			err = fmt.Errorf("Not found controller for '%s'->'%s'.", method, path)
+			return
+		}
+
+		controller = reflect.Indirect(reflect.ValueOf(opts.Controller)).Type()
+
+		action, err = newAction(controller, opts.Action, keys)
+		if err != nil {
+			return fmt.Errorf("Route '%s'->'%s': %s", method, path, err)
+		}
+	}
+
+	trees := r.trees
+	if opts.Host != "" {
+		table, err := r.hostTable(opts.Host)
+		if err != nil {
+			return err
+		}
+		trees = table.trees
+	}
+
+	root, ok := trees[method]
+	if !ok {
+		root = new(node)
+		trees[method] = root
+	}
+
+	route := Route{
+		Keys:           keys,
+		Regex:          regex,
+		Pattern:        path,
+		ControllerType: controller,
+		Options:        opts,
+		segments:       segments,
+		action:         action,
+	}
+
+	// Все варианты проверяются до вставки, чтобы при конфликте в дереве не осталось части из них
+	variants := expandOptional(segments)
+	for _, variant := range variants {
+		if err = root.conflict(variant); err != nil {
+			return fmt.Errorf("Route '%s'->'%s': %s", method, routeKey, err)
+		}
+	}
+
+	for _, variant := range variants {
+		err = root.insert(variant, &leaf{&route, segmentKeys(variant)})
+		if err != nil {
+			return fmt.Errorf("Route '%s'->'%s': %s", method, routeKey, err)
+		}
+	}
+
+	if opts.Name != "" {
+		r.names[opts.Name] = &route
+	}
+
+	routing[routeKey] = route
 	return
 }
 
@@ -129,8 +337,8 @@ func (r *Router) Copy(dest *Router) (err error) {
 	r.internalInit()
 
 	for method, srcRouting := range r.routes {
-		for routePath, routeDesc := range srcRouting {
-			err = dest.Add(method, routePath, routeDesc.Options)
+		for _, routeDesc := range srcRouting {
+			err = dest.Add(method, routeDesc.Pattern, routeDesc.Options)
 			if err != nil {
 				return
 			}
@@ -145,5 +353,11 @@ func (r *Router) internalInit() {
 		if r.routes == nil {
 			r.routes = make(Routes)
 		}
+		if r.trees == nil {
+			r.trees = make(map[string]*node)
+		}
+		if r.names == nil {
+			r.names = make(map[string]*Route)
+		}
 	})
 }
@@ -57,6 +57,76 @@ func TestRouterMatch(t *testing.T) {
 			ReqUrl:    "/a/1",
 			Res:       nil,
 		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/files/*path",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/files/a/b/c.txt",
+			Res:       &Match{Params: Params{"path": "a/b/c.txt"}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/files/*path",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/files/",
+			Res:       &Match{Params: Params{"path": ""}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/files/*path",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/file",
+			Res:       nil,
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/archive/:year/:month?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/archive/2017/05",
+			Res:       &Match{Params: Params{"year": "2017", "month": "05"}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/archive/:year/:month?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/archive/2017",
+			Res:       &Match{Params: Params{"year": "2017"}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/archive/:year/:month?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/archive",
+			Res:       nil,
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/a/:b?/:c?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/a/1/2",
+			Res:       &Match{Params: Params{"b": "1", "c": "2"}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/a/:b?/:c?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/a/1",
+			Res:       &Match{Params: Params{"b": "1"}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/a/:b?/:c?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/a",
+			Res:       &Match{Params: Params{}},
+		},
+		{
+			SrcMethod: http.MethodGet,
+			SrcUrl:    "/:page?",
+			ReqMethod: http.MethodGet,
+			ReqUrl:    "/",
+			Res:       &Match{Params: Params{}},
+		},
 	} {
 
 		router := new(Router)
@@ -148,7 +218,7 @@ func TestRouterCopy(t *testing.T) {
 			}
 
 			if len(td.Dst.routes) == 0 || len(td.Dst.routes) != len(td.Res.routes) {
-				t.Error("Not equal count: %d != %d", len(td.Dst.routes), len(td.Res.routes))
+				t.Errorf("Not equal count: %d != %d", len(td.Dst.routes), len(td.Res.routes))
 			} else {
 
 				for method, resRouting := range td.Res.routes {
@@ -183,3 +253,484 @@ func TestRouterCopy(t *testing.T) {
 		}
 	}
 }
+
+func TestRouterPriority(t *testing.T) {
+
+	router := new(Router)
+	for _, path := range []string{"/users/:id", "/users/new", "/users/:id/edit", "/users/new/:tab", "/u", "/users"} {
+		err := router.Add(http.MethodGet, path, &RouteOptions{
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, td := range []struct {
+		Url     string
+		Pattern string
+		Params  Params
+	}{
+		{"/users/new", "/users/new", Params{}},
+		{"/users/news", "/users/:id", Params{"id": "news"}},
+		{"/users/1", "/users/:id", Params{"id": "1"}},
+		{"/users/new/edit", "/users/new/:tab", Params{"tab": "edit"}},
+		{"/users/1/edit", "/users/:id/edit", Params{"id": "1"}},
+		{"/users/1/", "/users/:id", Params{"id": "1"}},
+		{"/users", "/users", Params{}},
+		{"/u", "/u", Params{}},
+		{"/us", "", nil},
+		{"/users/", "/users", Params{}},
+		{"/users//", "", nil},
+	} {
+		match := router.Match(http.MethodGet, td.Url)
+
+		if td.Pattern == "" {
+			if match != nil {
+				t.Errorf("Fail: '%s' matched '%s'", td.Url, match.Pattern)
+			}
+			continue
+		}
+
+		if match == nil {
+			t.Errorf("Fail: '%s' not matched", td.Url)
+			continue
+		}
+
+		if match.Pattern != td.Pattern {
+			t.Errorf("Fail: '%s' matched '%s' instead of '%s'", td.Url, match.Pattern, td.Pattern)
+		}
+
+		if len(match.Params) != len(td.Params) {
+			t.Errorf("Fail: '%s' %v != %v", td.Url, match.Params, td.Params)
+		}
+		for k, v := range td.Params {
+			if match.Params[k] != v {
+				t.Errorf("Fail: '%s' %v != %v", td.Url, match.Params, td.Params)
+			}
+		}
+	}
+}
+
+func TestRouterConstraints(t *testing.T) {
+
+	router := new(Router)
+	for _, path := range []string{
+		"/orders/:slug",
+		"/orders/:id<int>",
+		"/u/:slug<[a-z0-9-]+>",
+		"/d/:date<date>",
+		"/r/:path<[a-z]+/[a-z]+>",
+	} {
+		err := router.Add(http.MethodGet, path, &RouteOptions{
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, td := range []struct {
+		Url     string
+		Pattern string
+	}{
+		{"/orders/15", "/orders/:id<int>"},
+		{"/orders/new", "/orders/:slug"},
+		{"/u/some-slug-1", "/u/:slug<[a-z0-9-]+>"},
+		{"/u/Some", ""},
+		{"/d/2017-05-31", "/d/:date<date>"},
+		{"/d/2017-02-31", ""},
+		{"/d/today", ""},
+	} {
+		match := router.Match(http.MethodGet, td.Url)
+
+		switch {
+		case td.Pattern == "" && match != nil:
+			t.Errorf("Fail: '%s' matched '%s'", td.Url, match.Pattern)
+		case td.Pattern != "" && match == nil:
+			t.Errorf("Fail: '%s' not matched", td.Url)
+		case match != nil && match.Pattern != td.Pattern:
+			t.Errorf("Fail: '%s' matched '%s' instead of '%s'", td.Url, match.Pattern, td.Pattern)
+		}
+	}
+
+	if err := router.Add(http.MethodGet, "/x/:id<[a-z>", &RouteOptions{Controller: new(TestController), Action: "Invoke"}); err == nil {
+		t.Error("Invalid constraint accepted")
+	}
+}
+
+func TestParams(t *testing.T) {
+
+	params := Params{
+		"id":   "15",
+		"bad":  "15a",
+		"uuid": "0E2B1D7A-2F1B-4B6A-9C4E-0A5B6C7D8E9F",
+		"date": "2017-05-31",
+	}
+
+	if v, err := params.Int("id"); err != nil || v != 15 {
+		t.Error("Int:", v, err)
+	}
+	if v, err := params.Int64("id"); err != nil || v != 15 {
+		t.Error("Int64:", v, err)
+	}
+	if _, err := params.Int("bad"); err == nil {
+		t.Error("Int: invalid value accepted")
+	}
+	if _, err := params.Int("none"); err == nil {
+		t.Error("Int: missing param accepted")
+	}
+	if v, err := params.UUID("uuid"); err != nil || v != "0e2b1d7a-2f1b-4b6a-9c4e-0a5b6c7d8e9f" {
+		t.Error("UUID:", v, err)
+	}
+	if _, err := params.UUID("id"); err == nil {
+		t.Error("UUID: invalid value accepted")
+	}
+	if v, err := params.Time("date", ""); err != nil || v.Day() != 31 {
+		t.Error("Time:", v, err)
+	}
+}
+
+func TestRouterURL(t *testing.T) {
+
+	router := new(Router)
+	for name, path := range map[string]string{
+		"user":    "/users/:id<int>",
+		"archive": "/archive/:year/:month?",
+		"file":    "/files/*path",
+		"root":    "/:page?",
+	} {
+		err := router.Add(http.MethodGet, path, &RouteOptions{
+			Name:       name,
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, td := range []struct {
+		Name   string
+		Params []interface{}
+		Res    string
+	}{
+		{"user", []interface{}{"id", 15}, "/users/15"},
+		{"user", []interface{}{"id", "abc"}, ""},
+		{"user", []interface{}{}, ""},
+		{"user", []interface{}{"id", 15, "tab", "x"}, ""},
+		{"user", []interface{}{"id"}, ""},
+		{"archive", []interface{}{"year", 2017, "month", "05"}, "/archive/2017/05"},
+		{"archive", []interface{}{"year", 2017}, "/archive/2017"},
+		{"file", []interface{}{"path", "a b/c.txt"}, "/files/a%20b/c.txt"},
+		{"root", []interface{}{}, "/"},
+		{"none", []interface{}{}, ""},
+	} {
+		res, err := router.URL(td.Name, td.Params...)
+
+		if td.Res == "" {
+			if err == nil {
+				t.Errorf("Fail: %s %v -> '%s'", td.Name, td.Params, res)
+			}
+		} else if err != nil || res != td.Res {
+			t.Errorf("Fail: %s %v -> '%s' (%v)", td.Name, td.Params, res, err)
+		}
+	}
+
+	err := router.Add(http.MethodPost, "/users", &RouteOptions{
+		Name:       "user",
+		Controller: new(TestController),
+		Action:     "Invoke",
+	})
+	if err == nil {
+		t.Error("Duplicate route name accepted")
+	}
+}
+
+func TestRouterGroup(t *testing.T) {
+
+	router := new(Router)
+	err := router.Group("/api/v1/", RouteOptions{
+		MiddlewareGroup: "api",
+		Controller:      new(TestController),
+		BodyLength:      1024,
+	}, func(g *Group) {
+		g.Get("/", RouteOptions{Action: "Invoke"})
+		g.Post("/users", RouteOptions{Action: "Invoke", BodyLength: 2048})
+
+		g.Group("/admin", RouteOptions{MiddlewareGroup: "admin", I18n: true}, func(g *Group) {
+			g.Delete("/users/:id", RouteOptions{Action: "Invoke"})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, td := range []struct {
+		Method          string
+		Url             string
+		MiddlewareGroup string
+		BodyLength      int64
+		I18n            bool
+	}{
+		{http.MethodGet, "/api/v1", "api", 1024, false},
+		{http.MethodPost, "/api/v1/users", "api", 2048, false},
+		{http.MethodDelete, "/api/v1/admin/users/1", "admin", 1024, true},
+	} {
+		match := router.Match(td.Method, td.Url)
+		if match == nil {
+			t.Errorf("Fail: '%s' not matched", td.Url)
+			continue
+		}
+
+		opts := match.Options
+		if opts.MiddlewareGroup != td.MiddlewareGroup || opts.BodyLength != td.BodyLength || opts.I18n != td.I18n {
+			t.Errorf("Fail: '%s' %+v", td.Url, opts)
+		}
+	}
+
+	dest := new(Router)
+	if err := router.Copy(dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest.Match(http.MethodDelete, "/api/v1/admin/users/1") == nil {
+		t.Error("Group routes are not copied")
+	}
+
+	err = router.Group("/api/v1", RouteOptions{}, func(g *Group) {
+		g.Get("/", RouteOptions{Action: "Missing", Controller: new(TestController)})
+		g.Get("/other", RouteOptions{Action: "Invoke", Controller: new(TestController)})
+	})
+	if err == nil {
+		t.Error("Group error is lost")
+	}
+	if router.Match(http.MethodGet, "/api/v1/other") != nil {
+		t.Error("Group continues after error")
+	}
+}
+
+func TestRouterHost(t *testing.T) {
+
+	router := new(Router)
+	for _, td := range []struct {
+		Host string
+		Path string
+	}{
+		{"", "/users/:id"},
+		{"", "/about"},
+		{":tenant.example.com", "/users/:id"},
+		{"admin.example.com", "/users/:id"},
+		{":tenant.:region.example.com", "/stats"},
+	} {
+		err := router.Add(http.MethodGet, td.Path, &RouteOptions{
+			Host:       td.Host,
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, td := range []struct {
+		Host   string
+		Url    string
+		Host2  string
+		Params Params
+	}{
+		{"acme.example.com", "/users/1", ":tenant.example.com", Params{"tenant": "acme", "id": "1"}},
+		{"ACME.example.com:8080", "/users/1", ":tenant.example.com", Params{"tenant": "ACME", "id": "1"}},
+		{"admin.example.com", "/users/1", "admin.example.com", Params{"id": "1"}},
+		{"acme.eu.example.com", "/stats", ":tenant.:region.example.com", Params{"tenant": "acme", "region": "eu"}},
+		{"acme.example.com", "/about", "", Params{}},
+		{"example.com", "/users/1", "", Params{"id": "1"}},
+		{"a.b.c.example.com", "/stats", "-", nil},
+	} {
+		match := router.MatchHost(http.MethodGet, td.Host, td.Url)
+		if td.Host2 == "-" {
+			if match != nil {
+				t.Errorf("Fail: %s%s matched", td.Host, td.Url)
+			}
+			continue
+		}
+
+		if match == nil {
+			t.Errorf("Fail: %s%s not matched", td.Host, td.Url)
+			continue
+		}
+
+		if match.Options.Host != td.Host2 || len(match.Params) != len(td.Params) {
+			t.Errorf("Fail: %s%s -> %s %v", td.Host, td.Url, match.Options.Host, match.Params)
+		}
+		for k, v := range td.Params {
+			if match.Params[k] != v {
+				t.Errorf("Fail: %s%s -> %v", td.Host, td.Url, match.Params)
+			}
+		}
+	}
+
+	// Имя параметра хоста совпадает с параметром пути
+	err := router.Add(http.MethodGet, "/posts/:tenant", &RouteOptions{
+		Host:       ":tenant.example.com",
+		Controller: new(TestController),
+		Action:     "Invoke",
+	})
+	if err == nil {
+		t.Error("Route with duplicate host and path param accepted")
+	}
+
+	dest := new(Router)
+	if err := router.Copy(dest); err != nil {
+		t.Fatal(err)
+	}
+	if match := dest.MatchHost(http.MethodGet, "acme.example.com", "/users/1"); match == nil || match.Options.Host == "" {
+		t.Error("Host routes are not copied")
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+
+	router := new(Router)
+	router.Add(http.MethodPost, "/b", &RouteOptions{Controller: new(TestController), Action: "Invoke", MiddlewareGroup: "auth"})
+	router.Add(http.MethodGet, "/b", &RouteOptions{Controller: new(TestController), Action: "Invoke", Name: "b"})
+	router.Add(http.MethodGet, "/a/:id", &RouteOptions{Controller: new(TestController), Action: "Invoke", BodyLength: 10})
+
+	routes := router.Routes()
+	if len(routes) != 3 {
+		t.Fatal(routes)
+	}
+
+	for i, td := range []RouteInfo{
+		{Method: http.MethodGet, Pattern: "/a/:id", Controller: "webgo.TestController", Action: "Invoke", BodyLength: 10},
+		{Method: http.MethodGet, Pattern: "/b", Name: "b", Controller: "webgo.TestController", Action: "Invoke"},
+		{Method: http.MethodPost, Pattern: "/b", Controller: "webgo.TestController", Action: "Invoke", MiddlewareGroup: "auth"},
+	} {
+		if routes[i] != td {
+			t.Errorf("Fail: %+v != %+v", routes[i], td)
+		}
+	}
+}
+
+func TestRouterActionSignature(t *testing.T) {
+
+	for action, valid := range map[string]bool{
+		"Failed":   true,
+		"Ok":       true,
+		"Init":     false,
+		"Prepare":  false,
+		"Redirect": false,
+	} {
+		err := new(Router).Add(http.MethodGet, "/", &RouteOptions{
+			Controller: new(ErrorController),
+			Action:     action,
+		})
+		if valid != (err == nil) {
+			t.Errorf("Fail: '%s' %v", action, err)
+		}
+	}
+
+	for action, valid := range map[string]bool{
+		"Show":   true,
+		"Search": true,
+		"Page":   false,
+		"Raw":    false,
+	} {
+		err := new(Router).Add(http.MethodGet, "/users/:id", &RouteOptions{
+			Controller: new(UsersController),
+			Action:     action,
+		})
+		if valid != (err == nil) {
+			t.Errorf("Fail: '%s' %v", action, err)
+		}
+	}
+
+	// Скалярные параметры должны покрывать все параметры маршрута
+	for action, valid := range map[string]bool{
+		"Show":   false,
+		"Search": true,
+		"Page":   true,
+	} {
+		err := new(Router).Add(http.MethodGet, "/users/:uid/posts/:id", &RouteOptions{
+			Controller: new(UsersController),
+			Action:     action,
+		})
+		if valid != (err == nil) {
+			t.Errorf("Fail: '%s' %v", action, err)
+		}
+	}
+}
+
+func TestRouterInvalidPattern(t *testing.T) {
+
+	for _, path := range []string{
+		"a",
+		"/a:b",
+		"/files/*path/x",
+		"/a/:b?/c",
+		"/a/:b?/:c",
+		"/a/:b-c",
+	} {
+		err := new(Router).Add(http.MethodGet, path, &RouteOptions{
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err == nil {
+			t.Errorf("Pattern accepted: '%s'", path)
+		}
+	}
+}
+
+func TestRouterOptionalConflict(t *testing.T) {
+
+	router := new(Router)
+	opts := &RouteOptions{Controller: new(TestController), Action: "Invoke"}
+
+	if err := router.Add(http.MethodGet, "/a", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Вариант /a конфликтует, вариант /a/:x не должен остаться в дереве
+	if err := router.Add(http.MethodGet, "/a/:x?", opts); err == nil {
+		t.Fatal("Conflicting route accepted: '/a/:x?'")
+	}
+
+	if match := router.Match(http.MethodGet, "/a/1"); match != nil {
+		t.Errorf("Fail: '/a/1' matched '%s'", match.Pattern)
+	}
+
+	if err := router.Add(http.MethodGet, "/a/:x", opts); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRouterLookupAllocs(t *testing.T) {
+
+	router := new(Router)
+	for _, path := range []string{"/users/:id/posts/:post", "/users/new", "/static/path"} {
+		err := router.Add(http.MethodGet, path, &RouteOptions{
+			Controller: new(TestController),
+			Action:     "Invoke",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := router.trees[http.MethodGet]
+
+	allocs := testing.AllocsPerRun(100, func() {
+		var buf [maxParams]string
+		if l, _ := root.lookup("/users/1/posts/2", buf[:0], false); l == nil {
+			t.Fatal("Route not found")
+		}
+		if l, _ := root.lookup("/static/path", buf[:0], false); l == nil {
+			t.Fatal("Route not found")
+		}
+	})
+
+	if allocs != 0 {
+		t.Errorf("Lookup allocates: %v", allocs)
+	}
+}
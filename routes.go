@@ -0,0 +1,125 @@
+package webgo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// RouteInfo - описание зарегистрированного маршрута
+type RouteInfo struct {
+	Method          string
+	Host            string
+	Pattern         string
+	Name            string
+	Controller      string
+	Action          string
+	MiddlewareGroup string
+	BodyLength      int64
+	Timeout         time.Duration
+}
+
+// Routes возвращает список маршрутов, отсортированный по хосту, шаблону и методу
+func (r *Router) Routes() []RouteInfo {
+	r.internalInit()
+
+	list := make([]RouteInfo, 0)
+	for method, routing := range r.routes {
+		for _, route := range routing {
+			info := RouteInfo{
+				Method:          method,
+				Host:            route.Options.Host,
+				Pattern:         route.Pattern,
+				Name:            route.Options.Name,
+				Action:          route.Options.Action,
+				MiddlewareGroup: route.Options.MiddlewareGroup,
+				BodyLength:      route.Options.BodyLength,
+				Timeout:         route.Options.Timeout,
+			}
+
+			if route.ControllerType != nil {
+				info.Controller = route.ControllerType.String()
+			} else if route.Options.Handler != nil {
+				info.Controller = reflect.TypeOf(route.Options.Handler).String()
+			}
+
+			list = append(list, info)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		return a.Method < b.Method
+	})
+
+	return list
+}
+
+// PrintRoutes выводит таблицу маршрутов приложения.
+// Для маршрутов без собственного ограничения выводится ограничение приложения.
+func (a *App) PrintRoutes(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tHOST\tPATTERN\tNAME\tCONTROLLER\tACTION\tMIDDLEWARE\tBODY LIMIT\tTIMEOUT")
+
+	for _, info := range a.router.Routes() {
+		if info.BodyLength == 0 {
+			info.BodyLength = a.maxBodyLength
+		}
+		if info.Timeout == 0 {
+			info.Timeout = a.timeout
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			info.Method,
+			dash(info.Host),
+			info.Pattern,
+			dash(info.Name),
+			dash(info.Controller),
+			dash(info.Action),
+			dash(info.MiddlewareGroup),
+			info.BodyLength,
+			info.Timeout,
+		)
+	}
+
+	return tw.Flush()
+}
+
+func PrintRoutes(w io.Writer) error {
+	return app.PrintRoutes(w)
+}
+
+// runCommand выполняет служебную команду из аргументов запуска, например `app routes`.
+// Возвращает true, если команда выполнена и запускать сервер не нужно.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "routes":
+		if err := PrintRoutes(os.Stdout); err != nil {
+			LOGGER.Fatal(err)
+		}
+		return true
+	}
+
+	return false
+}
+
+func dash(val string) string {
+	if val == "" {
+		return "-"
+	}
+	return val
+}
@@ -0,0 +1,384 @@
+package webgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/IntelliQru/i18n"
+)
+
+type (
+	// schema - план заполнения структуры по тегам, строится один раз для каждого типа
+	schema struct {
+		typ    reflect.Type
+		json   bool
+		tagged bool
+		fields []schemaField
+	}
+	schemaField struct {
+		index  int
+		name   string
+		source string
+		slice  bool
+		nested *schema
+	}
+	// bindInput - значения запроса, из которых заполняется структура
+	bindInput struct {
+		ctx   *Context
+		form  url.Values
+		query url.Values
+		T     i18n.TFuncHandler
+	}
+	schemaEntry struct {
+		schema *schema
+		err    error
+	}
+)
+
+// Теги полей структуры и соответствующие им источники значений:
+// form - тело запроса (форма, multipart, декодеры кроме JSON), а без тела - строка запроса,
+// query - строка запроса, header - заголовок, cookie - cookie, path - параметр маршрута.
+// Тело JSON разбирается только по тегам json (см. decodeJSON).
+var bindSources = []string{"form", "query", "header", "cookie", "path"}
+
+var schemas sync.Map
+
+// schemaOf возвращает план заполнения для типа структуры
+func schemaOf(typ reflect.Type) (*schema, error) {
+	if entry, ok := schemas.Load(typ); ok {
+		return entry.(*schemaEntry).schema, entry.(*schemaEntry).err
+	}
+
+	s, err := newSchema(typ, map[reflect.Type]bool{})
+	schemas.Store(typ, &schemaEntry{s, err})
+	return s, err
+}
+
+// newSchema разбирает теги структуры. Поля без тегов заполняются из формы по имени,
+// вложенные структуры - по ключам вида address.city или address[city].
+func newSchema(typ reflect.Type, building map[reflect.Type]bool) (*schema, error) {
+	if building[typ] {
+		return nil, fmt.Errorf("Recursive struct %s", typ)
+	}
+	building[typ] = true
+	defer delete(building, typ)
+
+	s := &schema{typ: typ}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		if _, ok := field.Tag.Lookup("json"); ok {
+			s.json = true
+		}
+
+		tagged := false
+		for _, source := range bindSources {
+			tag, ok := field.Tag.Lookup(source)
+			if !ok {
+				continue
+			}
+			tagged = true
+
+			name := tagName(tag)
+			if name == "" {
+				continue
+			}
+
+			f, err := newSchemaField(field, i, name, source, building)
+			if err != nil {
+				return nil, err
+			}
+			if f == nil {
+				return nil, fmt.Errorf("Unsupported type %s of field '%s'", field.Type, field.Name)
+			}
+			s.fields = append(s.fields, *f)
+		}
+
+		if tagged {
+			s.tagged = true
+			continue
+		}
+
+		// Без тегов используется имя поля, а также имя из тега json, если оно отличается.
+		// Вложенные структуры заполняются только с тегом form, встроенные - без префикса
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+
+		names := []string{field.Name}
+		if name := tagName(jsonTag); name != "" && name != field.Name {
+			names = append(names, name)
+		}
+
+		if field.Anonymous {
+			names = []string{""}
+		} else if !isScalar(field.Type) && !(field.Type.Kind() == reflect.Slice && isScalar(field.Type.Elem())) {
+			continue
+		}
+
+		for _, name := range names {
+			f, err := newSchemaField(field, i, name, "form", building)
+			if err != nil {
+				return nil, err
+			}
+			if f != nil && (f.nested == nil) != field.Anonymous {
+				s.fields = append(s.fields, *f)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// newSchemaField возвращает nil для неподдерживаемого типа поля
+func newSchemaField(field reflect.StructField, index int, name, source string, building map[reflect.Type]bool) (*schemaField, error) {
+	f := &schemaField{index: index, name: name, source: source}
+
+	typ := field.Type
+	if isScalar(typ) {
+		return f, nil
+	}
+
+	if typ.Kind() == reflect.Slice && isScalar(typ.Elem()) {
+		f.slice = true
+		return f, nil
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || source != "form" {
+		return nil, nil
+	}
+
+	nested, err := newSchema(typ, building)
+	if err != nil {
+		return nil, err
+	}
+	f.nested = nested
+	return f, nil
+}
+
+// bind заполняет структуру, возвращает true, если было заполнено хотя бы одно поле.
+// Ошибки преобразования собираются по всем полям.
+func (s *schema) bind(in *bindInput, val reflect.Value, prefix string) (set bool, errs ValidationErrors) {
+	for _, f := range s.fields {
+		field := val.Field(f.index)
+		key := joinKey(prefix, f.name)
+
+		if f.nested != nil {
+			target := field
+			if field.Kind() == reflect.Ptr {
+				target = reflect.New(field.Type().Elem()).Elem()
+			}
+
+			ok, nestedErrs := f.nested.bind(in, target, key)
+			errs = append(errs, nestedErrs...)
+			if ok && field.Kind() == reflect.Ptr {
+				field.Set(target.Addr())
+			}
+			set = set || ok
+			continue
+		}
+
+		values := in.values(f.source, key, f.name)
+		if len(values) == 0 {
+			continue
+		}
+
+		if (!f.slice && len(values) > 1) || setValues(field, values) != nil {
+			errs = append(errs, newFieldError(in.T, key, "type", typeName(field.Type()), strings.Join(values, ",")))
+			continue
+		}
+		set = true
+	}
+
+	return
+}
+
+// bindSchema заполняет структуру и проверяет правила validate, errs - ошибки, найденные ранее
+func (c *Context) bindSchema(val reflect.Value, errs ValidationErrors) error {
+	s, err := schemaOf(val.Type())
+	if err != nil {
+		return err
+	}
+
+	v, err := validationOf(val.Type())
+	if err != nil {
+		return err
+	}
+
+	in := c.bindInput()
+
+	_, bindErrs := s.bind(in, val, "")
+	errs = v.validate(in.T, val, "", append(errs, bindErrs...))
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeJSON разбирает тело JSON в v, ошибки типов полей возвращаются списком.
+// В строгом режиме маршрута (RouteOptions.StrictJSON) неизвестные поля считаются ошибкой.
+func (c *Context) decodeJSON(v interface{}) (ValidationErrors, error) {
+	dec := json.NewDecoder(bytes.NewReader(c._Body))
+	if c.options != nil && c.options.StrictJSON {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(v)
+
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		// typeErr.Value содержит тип значения JSON, само значение берется из разобранного тела
+		return ValidationErrors{newFieldError(i18n.Tfunc(c.Lang), typeErr.Field, "type", typeErr.Type.String(), c.jsonValue(typeErr.Field))}, nil
+	}
+
+	// Decoder не экспортирует тип ошибки неизвестного поля
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return ValidationErrors{newFieldError(i18n.Tfunc(c.Lang), field, "unknown", "", "")}, nil
+	}
+
+	return nil, err
+}
+
+// jsonValue возвращает значение тела JSON по пути вида a.b для сообщения об ошибке.
+// Объекты и массивы возвращаются в виде JSON, ненайденное значение - пустой строкой.
+func (c *Context) jsonValue(path string) string {
+	data, err := c.Data()
+	if err != nil {
+		return ""
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if data, ok = obj[key]; !ok {
+			return ""
+		}
+	}
+
+	switch data.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		buf, _ := json.Marshal(data)
+		return string(buf)
+	}
+	return fmt.Sprint(data)
+}
+
+// values возвращает значения поля из источника. Ключ формы учитывает вложенность,
+// остальные источники используют имя из тега как есть.
+func (in *bindInput) values(source, key, name string) []string {
+	r := in.ctx.Request
+
+	switch source {
+	case "form":
+		return in.form[key]
+	case "query":
+		return in.query[name]
+	case "header":
+		return r.Header[http.CanonicalHeaderKey(name)]
+	case "cookie":
+		if cookie, err := r.Cookie(name); err == nil {
+			return []string{cookie.Value}
+		}
+	case "path":
+		if val, ok := in.ctx.Params[name]; ok {
+			return []string{val}
+		}
+	}
+
+	return nil
+}
+
+// bindInput собирает значения запроса. Поля формы берутся из тела запроса
+// в зависимости от типа содержимого, разобранный декодером объект (кроме JSON) разворачивается в ключи вида a.b.
+func (c *Context) bindInput() *bindInput {
+	in := &bindInput{
+		ctx:   c,
+		form:  url.Values{},
+		query: c.Request.URL.Query(),
+		T:     i18n.Tfunc(c.Lang),
+	}
+
+	switch c.ContentType {
+	case CT_FORM, CT_MULTIPART:
+		formValues(in.form, c.Body)
+	case "":
+		formValues(in.form, c.Query)
+	default:
+		// Тело JSON разбирается в структуру только decodeJSON, потоковое тело не читается повторно
+		if c.decoder != nil && !isStream(c.decoder) && !c.isJSON() {
+			if data, err := c.Data(); err == nil {
+				flattenJSON(in.form, "", data)
+			}
+		}
+	}
+
+	return in
+}
+
+// formValues копирует значения формы, приводя ключи a[b][c] и a[] к виду a.b.c и a
+func formValues(dst url.Values, src map[string]interface{}) {
+	for key, val := range src {
+		key = strings.Replace(key, "[]", "", -1)
+		key = strings.Replace(key, "][", ".", -1)
+		key = strings.Replace(key, "[", ".", -1)
+		key = strings.TrimSuffix(key, "]")
+
+		dst[key] = append(dst[key], queryValues(val)...)
+	}
+}
+
+// flattenJSON разворачивает объект JSON в значения формы
+func flattenJSON(dst url.Values, prefix string, data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			flattenJSON(dst, joinKey(prefix, key), val)
+		}
+	case []interface{}:
+		for _, val := range v {
+			switch val.(type) {
+			case map[string]interface{}, []interface{}, nil:
+			default:
+				dst[prefix] = append(dst[prefix], fmt.Sprint(val))
+			}
+		}
+	case nil:
+	default:
+		dst[prefix] = append(dst[prefix], fmt.Sprint(v))
+	}
+}
+
+// typeName возвращает имя типа для сообщений, указатели не упоминаются
+func typeName(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.String()
+}
+
+func joinKey(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case name == "":
+		return prefix
+	}
+	return prefix + "." + name
+}
@@ -1,4 +1,389 @@
 package webgo
 
-// TODO: Загрузка сессии, выбор хранилища (память, редис)
-// TODO: Базовые методы
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// SessionStore - хранилище данных сессий. Load возвращает nil без ошибки,
+	// если сессии нет или срок ее жизни истек.
+	SessionStore interface {
+		Load(id string) ([]byte, error)
+		Save(id string, data []byte, lifetime time.Duration) error
+		Delete(id string) error
+	}
+
+	// Session - данные сессии пользователя. Значения сохраняются через encoding/gob,
+	// собственные типы нужно зарегистрировать через gob.Register.
+	Session struct {
+		id        string
+		oldID     string
+		values    map[string]interface{}
+		changed   bool
+		destroyed bool
+		// chunks - количество частей cookie сессии в запросе, для сессий в cookie
+		chunks int
+	}
+
+	// sessionManager загружает сессию по cookie и сохраняет ее после действия.
+	// Данные хранятся либо в store, либо, если задан codec, в самой cookie.
+	sessionManager struct {
+		store    SessionStore
+		codec    *CookieCodec
+		name     string
+		lifetime time.Duration
+		secure   bool
+		sameSite http.SameSite
+	}
+)
+
+var errSessionsDisabled = errors.New("Sessions are disabled")
+
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteLaxMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// newSessionManager создает менеджер сессий по настройкам конфигурации:
+// sessionStore (memory, file, redis, cookie, off), sessionName, sessionLifetime (секунды),
+// sessionSecure, sessionSameSite (lax, strict, none), sessionRedis, sessionRedisPassword, sessionRedisDb,
+// для cookie - sessionSecret (ключи через запятую, первый подписывает) и sessionEncrypt.
+func newSessionManager() (*sessionManager, error) {
+	m := &sessionManager{
+		name:     "session_id",
+		lifetime: 24 * time.Hour,
+		secure:   cfgBool("sessionSecure"),
+	}
+
+	if CFG.Str("sessionName") != "" {
+		m.name = CFG.Str("sessionName")
+	}
+	if CFG.Int("sessionLifetime") > 0 {
+		m.lifetime = time.Duration(CFG.Int("sessionLifetime")) * time.Second
+	}
+
+	sameSite, ok := sameSiteModes[strings.ToLower(CFG.Str("sessionSameSite"))]
+	if !ok {
+		return nil, fmt.Errorf("Unknown sessionSameSite mode: '%s'.", CFG.Str("sessionSameSite"))
+	}
+	m.sameSite = sameSite
+
+	switch CFG.Str("sessionStore") {
+	case "", "memory":
+		m.store = NewMemoryStore()
+	case "file":
+		store, err := NewFileStore(path.Join(app.tmpDir, "sessions"))
+		if err != nil {
+			return nil, err
+		}
+		m.store = store
+	case "redis":
+		m.store = NewRedisStore(CFG.Str("sessionRedis"), CFG.Str("sessionRedisPassword"), CFG.Int("sessionRedisDb"))
+	case "cookie":
+		codec, err := newCookieCodec()
+		if err != nil {
+			return nil, err
+		}
+		m.codec = codec
+	case "off", "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unknown sessionStore: '%s'.", CFG.Str("sessionStore"))
+	}
+
+	return m, nil
+}
+
+// SetSessionStore заменяет хранилище сессий, nil отключает сессии
+func (a *App) SetSessionStore(store SessionStore) {
+	if store == nil {
+		a.sessions = nil
+		return
+	}
+
+	if a.sessions == nil {
+		a.sessions = &sessionManager{name: "session_id", lifetime: 24 * time.Hour, sameSite: http.SameSiteLaxMode}
+	}
+	a.sessions.store = store
+	a.sessions.codec = nil
+}
+
+func SetSessionStore(store SessionStore) {
+	app.SetSessionStore(store)
+}
+
+// Session возвращает сессию запроса. Сессия загружается при первом обращении
+// и сохраняется после Finish, если ее данные изменились.
+func (c *Context) Session() (*Session, error) {
+	if c.session != nil {
+		return c.session, nil
+	}
+
+	if app.sessions == nil {
+		return nil, errSessionsDisabled
+	}
+
+	sess, err := app.sessions.load(c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.session = sess
+	return sess, nil
+}
+
+// load читает сессию по cookie. Для неизвестного идентификатора создается новая сессия
+// с новым идентификатором, чтобы клиент не мог навязать свой.
+func (m *sessionManager) load(ctx *Context) (*Session, error) {
+	if m.codec != nil {
+		return m.loadCookie(ctx)
+	}
+
+	id := ctx.GetCookie(m.name)
+	if !validSessionID(id) {
+		return newSession()
+	}
+
+	data, err := m.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return newSession()
+	}
+
+	values := make(map[string]interface{})
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+	if err != nil {
+		LOGGER.Error(fmt.Errorf("Invalid session data: %s", err))
+		return newSession()
+	}
+
+	return &Session{id: id, values: values}, nil
+}
+
+// save сохраняет измененную сессию и отправляет cookie, вызывается до отправки ответа
+func (m *sessionManager) save(ctx *Context) error {
+	sess := ctx.session
+	if sess == nil {
+		return nil
+	}
+
+	if m.codec != nil {
+		return m.saveCookie(ctx)
+	}
+
+	if sess.oldID != "" {
+		if err := m.store.Delete(sess.oldID); err != nil {
+			return err
+		}
+		sess.oldID = ""
+	}
+
+	if sess.destroyed {
+		ctx.SetCookieOpts(m.cookie(m.name, "", -1))
+		return m.store.Delete(sess.id)
+	}
+
+	if !sess.changed {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sess.values); err != nil {
+		return err
+	}
+
+	if err := m.store.Save(sess.id, buf.Bytes(), m.lifetime); err != nil {
+		return err
+	}
+	sess.changed = false
+
+	ctx.SetCookieOpts(m.cookie(m.name, sess.id, int(m.lifetime/time.Second)))
+	return nil
+}
+
+// cookie возвращает cookie сессии, maxAge < 0 удаляет ее
+func (m *sessionManager) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: m.sameSite,
+	}
+}
+
+// sessionWriter сохраняет сессию и сообщения перед отправкой заголовков ответа,
+// чтобы cookie попали в ответ, даже если его пишет middleware
+type sessionWriter struct {
+	http.ResponseWriter
+	app         *App
+	ctx         *Context
+	wroteHeader bool
+	hijacked    bool
+}
+
+// wrapResponse подменяет ResponseWriter контекста на sessionWriter
+func (a *App) wrapResponse(ctx *Context) *sessionWriter {
+	sw := &sessionWriter{ResponseWriter: ctx.Response, app: a, ctx: ctx}
+	ctx.Response = sw
+	return sw
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.app.commitSession(w.ctx)
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.app.commitSession(w.ctx)
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.app.commitSession(w.ctx)
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not support Hijack")
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish сохраняет сессию, если middleware прервал запрос, не начав ответ
+func (w *sessionWriter) finish() {
+	w.app.commitSession(w.ctx)
+	if !w.wroteHeader && !w.hijacked {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// commitSession один раз за запрос сохраняет сообщения и сессию, до отправки заголовков
+func (a *App) commitSession(ctx *Context) {
+	if ctx.committed {
+		return
+	}
+	ctx.committed = true
+
+	a.saveFlashes(ctx)
+	a.saveSession(ctx)
+}
+
+// saveSession сохраняет сессию запроса, ошибка хранилища не прерывает ответ
+func (a *App) saveSession(ctx *Context) {
+	if a.sessions == nil {
+		return
+	}
+
+	if err := a.sessions.save(ctx); err != nil {
+		LOGGER.Error(fmt.Errorf("Session save error: %s", err))
+	}
+}
+
+func newSession() (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{id: id, values: make(map[string]interface{})}, nil
+}
+
+// newSessionID возвращает случайный идентификатор из 32 байт в base64url
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// validSessionID проверяет формат идентификатора, прежде чем обращаться к хранилищу
+func validSessionID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+func (s *Session) Set(key string, val interface{}) {
+	s.values[key] = val
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// Clear удаляет все значения сессии
+func (s *Session) Clear() {
+	s.values = make(map[string]interface{})
+	s.changed = true
+}
+
+// Regenerate меняет идентификатор сессии с сохранением данных.
+// Вызывается при смене привилегий (вход, выход, смена роли), чтобы старый идентификатор стал недействителен.
+func (s *Session) Regenerate() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = id
+	s.changed = true
+	return nil
+}
+
+// Destroy удаляет сессию из хранилища и cookie у клиента
+func (s *Session) Destroy() {
+	s.values = make(map[string]interface{})
+	s.destroyed = true
+}
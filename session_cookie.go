@@ -0,0 +1,286 @@
+package webgo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// CookieCodec подписывает и при необходимости шифрует значения cookie.
+	// Подписывает первый ключ, проверяются все: для смены ключа новый ставится первым,
+	// старый остается в списке, пока не истекут выданные им cookie.
+	CookieCodec struct {
+		keys    []cookieKey
+		encrypt bool
+	}
+
+	cookieKey struct {
+		aead   cipher.AEAD
+		macKey []byte
+	}
+
+	// cookieSession - содержимое cookie сессии
+	cookieSession struct {
+		ID     string
+		Values map[string]interface{}
+	}
+)
+
+const (
+	// cookieChunkSize - размер части значения cookie, с запасом на имя и атрибуты до лимита 4KB
+	cookieChunkSize = 3800
+	// cookieMaxChunks - наибольшее количество частей одной cookie
+	cookieMaxChunks = 10
+	// cookieMinKeyLen - наименьшая длина ключа
+	cookieMinKeyLen = 16
+)
+
+var errInvalidCookie = errors.New("Invalid cookie value")
+
+// NewCookieCodec создает кодек по ключам, первый ключ используется для подписи.
+// При encrypt значения шифруются AES-256-GCM, ключи шифрования и подписи выводятся из переданных ключей.
+func NewCookieCodec(encrypt bool, keys ...[]byte) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("Cookie codec requires at least one key")
+	}
+
+	codec := &CookieCodec{encrypt: encrypt}
+	for _, key := range keys {
+		if len(key) < cookieMinKeyLen {
+			return nil, fmt.Errorf("Cookie key must be at least %d bytes", cookieMinKeyLen)
+		}
+
+		block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		codec.keys = append(codec.keys, cookieKey{aead: aead, macKey: deriveKey(key, "sign")})
+	}
+
+	return codec, nil
+}
+
+// Encode возвращает значение cookie name: срок жизни maxAge записывается в подписанные данные,
+// поэтому cookie с истекшим сроком не принимается, даже если браузер ее прислал.
+func (c *CookieCodec) Encode(name string, data []byte, maxAge time.Duration) (string, error) {
+	payload := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Add(maxAge).Unix()))
+	copy(payload[8:], data)
+
+	key := c.keys[0]
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = key.aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, key.mac(name, payload)...)), nil
+}
+
+// Decode проверяет подпись и срок жизни значения cookie name и возвращает данные
+func (c *CookieCodec) Decode(name, value string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < sha256.Size {
+		return nil, errInvalidCookie
+	}
+	payload, mac := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+
+	for _, key := range c.keys {
+		if !hmac.Equal(mac, key.mac(name, payload)) {
+			continue
+		}
+
+		if c.encrypt {
+			size := key.aead.NonceSize()
+			if len(payload) < size {
+				return nil, errInvalidCookie
+			}
+			payload, err = key.aead.Open(nil, payload[:size], payload[size:], []byte(name))
+			if err != nil {
+				return nil, errInvalidCookie
+			}
+		}
+
+		if len(payload) < 8 || time.Now().Unix() > int64(binary.BigEndian.Uint64(payload)) {
+			return nil, errInvalidCookie
+		}
+		return payload[8:], nil
+	}
+
+	return nil, errInvalidCookie
+}
+
+func (k cookieKey) mac(name string, payload []byte) []byte {
+	h := hmac.New(sha256.New, k.macKey)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+// newCookieCodec создает кодек по настройкам sessionSecret (ключи через запятую) и sessionEncrypt
+func newCookieCodec() (*CookieCodec, error) {
+	var keys [][]byte
+	for _, key := range strings.Split(CFG.Str("sessionSecret"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("sessionSecret is required for cookie sessions.")
+	}
+	return NewCookieCodec(cfgBool("sessionEncrypt"), keys...)
+}
+
+// SetSessionCodec хранит сессии в cookie клиента вместо хранилища, nil отключает сессии
+func (a *App) SetSessionCodec(codec *CookieCodec) {
+	if codec == nil {
+		a.sessions = nil
+		return
+	}
+
+	if a.sessions == nil {
+		a.sessions = &sessionManager{name: "session_id", lifetime: 24 * time.Hour, sameSite: http.SameSiteLaxMode}
+	}
+	a.sessions.store = nil
+	a.sessions.codec = codec
+}
+
+func SetSessionCodec(codec *CookieCodec) {
+	app.SetSessionCodec(codec)
+}
+
+// loadCookie читает сессию из cookie. Значение больше cookieChunkSize хранится частями:
+// в основной cookie количество частей в виде "~N", части - в cookie name_1..name_N.
+func (m *sessionManager) loadCookie(ctx *Context) (*Session, error) {
+	value := ctx.GetCookie(m.name)
+
+	chunks := 0
+	if strings.HasPrefix(value, "~") {
+		n, err := strconv.Atoi(value[1:])
+		if err != nil || n < 1 || n > cookieMaxChunks {
+			return newSession()
+		}
+
+		chunks = n
+		var buf strings.Builder
+		for i := 1; i <= n; i++ {
+			buf.WriteString(ctx.GetCookie(m.chunkName(i)))
+		}
+		value = buf.String()
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	sess.chunks = chunks
+
+	if value == "" {
+		return sess, nil
+	}
+
+	data, err := m.codec.Decode(m.name, value)
+	if err != nil {
+		return sess, nil
+	}
+
+	var content cookieSession
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&content)
+	if err != nil || !validSessionID(content.ID) {
+		return sess, nil
+	}
+
+	sess.id = content.ID
+	if content.Values != nil {
+		sess.values = content.Values
+	}
+	return sess, nil
+}
+
+// saveCookie записывает сессию в cookie и удаляет лишние части от предыдущего значения
+func (m *sessionManager) saveCookie(ctx *Context) error {
+	sess := ctx.session
+	maxAge := int(m.lifetime / time.Second)
+
+	chunks := 0
+	defer func() {
+		for i := chunks + 1; i <= sess.chunks; i++ {
+			ctx.SetCookieOpts(m.cookie(m.chunkName(i), "", -1))
+		}
+		sess.chunks = chunks
+	}()
+
+	if sess.destroyed {
+		ctx.SetCookieOpts(m.cookie(m.name, "", -1))
+		return nil
+	}
+
+	if !sess.changed {
+		chunks = sess.chunks
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cookieSession{ID: sess.id, Values: sess.values}); err != nil {
+		return err
+	}
+
+	value, err := m.codec.Encode(m.name, buf.Bytes(), m.lifetime)
+	if err != nil {
+		return err
+	}
+	sess.changed = false
+
+	if len(value) <= cookieChunkSize {
+		ctx.SetCookieOpts(m.cookie(m.name, value, maxAge))
+		return nil
+	}
+
+	chunks = (len(value) + cookieChunkSize - 1) / cookieChunkSize
+	if chunks > cookieMaxChunks {
+		chunks = sess.chunks
+		return fmt.Errorf("Session cookie too large: %d bytes", len(value))
+	}
+
+	ctx.SetCookieOpts(m.cookie(m.name, "~"+strconv.Itoa(chunks), maxAge))
+	for i := 1; i <= chunks; i++ {
+		end := i * cookieChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		ctx.SetCookieOpts(m.cookie(m.chunkName(i), value[(i-1)*cookieChunkSize:end], maxAge))
+	}
+	return nil
+}
+
+func (m *sessionManager) chunkName(i int) string {
+	return m.name + "_" + strconv.Itoa(i)
+}
@@ -0,0 +1,190 @@
+package webgo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryStore хранит сессии в памяти процесса. Просроченные сессии удаляются
+	// при чтении и при периодической очистке во время записи.
+	MemoryStore struct {
+		mu    sync.Mutex
+		items map[string]memorySession
+		swept time.Time
+	}
+	memorySession struct {
+		data    []byte
+		expires time.Time
+	}
+
+	// FileStore хранит каждую сессию в отдельном файле каталога dir.
+	// Первые 8 байт файла - время истечения сессии в Unix-секундах.
+	FileStore struct {
+		dir   string
+		mu    sync.Mutex
+		swept time.Time
+	}
+)
+
+// sweepInterval - период очистки просроченных сессий
+const sweepInterval = time.Minute
+
+var errInvalidSessionID = errors.New("Invalid session id")
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]memorySession)}
+}
+
+func (s *MemoryStore) Load(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(item.expires) {
+		delete(s.items, id)
+		return nil, nil
+	}
+	return item.data, nil
+}
+
+func (s *MemoryStore) Save(id string, data []byte, lifetime time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		for key, item := range s.items {
+			if now.After(item.expires) {
+				delete(s.items, key)
+			}
+		}
+		s.swept = now
+	}
+
+	s.items[id] = memorySession{append([]byte(nil), data...), now.Add(lifetime)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.items, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// NewFileStore создает хранилище в каталоге dir, каталог создается при необходимости
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Load(id string) ([]byte, error) {
+	file, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 || time.Now().Unix() > int64(binary.BigEndian.Uint64(data)) {
+		os.Remove(file)
+		return nil, nil
+	}
+	return data[8:], nil
+}
+
+// Save записывает сессию через временный файл, чтобы параллельное чтение не видело половину данных
+func (s *FileStore) Save(id string, data []byte, lifetime time.Duration) error {
+	file, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.sweep()
+
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().Add(lifetime).Unix()))
+	copy(buf[8:], data)
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp_")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *FileStore) Delete(id string) error {
+	file, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path возвращает путь к файлу сессии, идентификатор не может выходить за пределы каталога
+func (s *FileStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", errInvalidSessionID
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// sweep удаляет просроченные сессии не чаще раза в sweepInterval
+func (s *FileStore) sweep() {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.swept) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.swept = now
+	s.mu.Unlock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+
+	for _, info := range files {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		// Load удаляет просроченный файл
+		s.Load(info.Name())
+	}
+}
@@ -0,0 +1,520 @@
+package webgo
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startRedisStub запускает минимальный сервер с протоколом Redis: AUTH, SELECT, GET, SET PX, DEL
+func startRedisStub(t *testing.T, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	expires := make(map[string]time.Time)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		authorized := password == ""
+
+		for {
+			req, err := readRESP(reader)
+			if err != nil {
+				return
+			}
+
+			var args []string
+			for _, arg := range req.([]interface{}) {
+				args = append(args, string(arg.([]byte)))
+			}
+
+			mu.Lock()
+			var reply string
+			switch cmd := strings.ToUpper(args[0]); {
+			case cmd == "AUTH":
+				authorized = args[1] == password
+				reply = "+OK\r\n"
+				if !authorized {
+					reply = "-ERR invalid password\r\n"
+				}
+			case !authorized:
+				reply = "-NOAUTH Authentication required.\r\n"
+			case cmd == "SELECT":
+				reply = "+OK\r\n"
+			case cmd == "GET":
+				val, ok := data[args[1]]
+				if ok && time.Now().After(expires[args[1]]) {
+					delete(data, args[1])
+					ok = false
+				}
+				reply = "$-1\r\n"
+				if ok {
+					reply = fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+				}
+			case cmd == "SET":
+				var ms int
+				fmt.Sscan(args[4], &ms)
+				data[args[1]] = args[2]
+				expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				reply = "+OK\r\n"
+			case cmd == "DEL":
+				_, ok := data[args[1]]
+				delete(data, args[1])
+				reply = ":0\r\n"
+				if ok {
+					reply = ":1\r\n"
+				}
+			default:
+				reply = "-ERR unknown command\r\n"
+			}
+			mu.Unlock()
+
+			conn.Write([]byte(reply))
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestSessionStores(t *testing.T) {
+
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]SessionStore{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+		"redis":  NewRedisStore(startRedisStub(t, "secret"), "secret", 1),
+	} {
+		id, _ := newSessionID()
+
+		if data, err := store.Load(id); data != nil || err != nil {
+			t.Errorf("%s: missing session %q %v", name, data, err)
+		}
+
+		if err := store.Save(id, []byte("data\r\n"), time.Minute); err != nil {
+			t.Errorf("%s: save %v", name, err)
+		}
+		if data, err := store.Load(id); string(data) != "data\r\n" || err != nil {
+			t.Errorf("%s: load %q %v", name, data, err)
+		}
+
+		if err := store.Delete(id); err != nil {
+			t.Errorf("%s: delete %v", name, err)
+		}
+		if data, err := store.Load(id); data != nil || err != nil {
+			t.Errorf("%s: deleted session %q %v", name, data, err)
+		}
+
+		store.Save(id, []byte("data"), -time.Second)
+		time.Sleep(5 * time.Millisecond)
+		if data, err := store.Load(id); data != nil || err != nil {
+			t.Errorf("%s: expired session %q %v", name, data, err)
+		}
+	}
+
+	if _, err := NewRedisStore(startRedisStub(t, "secret"), "wrong", 0).Load("id"); err == nil {
+		t.Error("redis: wrong password accepted")
+	}
+}
+
+type SessionController struct {
+	Controller
+}
+
+func (c *SessionController) Login() error {
+	sess, err := c.Ctx.Session()
+	if err != nil {
+		return err
+	}
+
+	sess.Set("user", c.Ctx.Params.Get("user"))
+	return sess.Regenerate()
+}
+
+func (c *SessionController) Show() error {
+	sess, err := c.Ctx.Session()
+	if err != nil {
+		return err
+	}
+
+	user, _ := sess.Get("user").(string)
+	c.Plain(user)
+	return nil
+}
+
+func (c *SessionController) Logout() error {
+	sess, err := c.Ctx.Session()
+	if err != nil {
+		return err
+	}
+
+	sess.Destroy()
+	return nil
+}
+
+func TestSession(t *testing.T) {
+
+	Get("/test/session/login/:user", RouteOptions{Controller: new(SessionController), Action: "Login"})
+	Get("/test/session/show", RouteOptions{Controller: new(SessionController), Action: "Show"})
+	Get("/test/session/logout", RouteOptions{Controller: new(SessionController), Action: "Logout"})
+
+	request := func(url string, cookie string) (string, *http.Cookie) {
+		res := serveRequest(http.MethodGet, url, http.Header{"Cookie": {"session_id=" + cookie}}, nil)
+
+		var sessCookie *http.Cookie
+		for _, c := range res.Result().Cookies() {
+			if c.Name == "session_id" {
+				sessCookie = c
+			}
+		}
+		return res.Body.String(), sessCookie
+	}
+
+	_, first := request("/test/session/login/john", "")
+	if first == nil || !first.HttpOnly || first.SameSite != http.SameSiteLaxMode || first.MaxAge != 86400 {
+		t.Fatalf("Invalid session cookie: %v", first)
+	}
+
+	if body, cookie := request("/test/session/show", first.Value); body != "john" || cookie != nil {
+		t.Errorf("Fail: show %q %v", body, cookie)
+	}
+
+	// Повторный вход меняет идентификатор, старый становится недействителен
+	_, second := request("/test/session/login/admin", first.Value)
+	if second == nil || second.Value == first.Value {
+		t.Fatalf("Session id is not rotated: %v", second)
+	}
+	if body, _ := request("/test/session/show", first.Value); body != "" {
+		t.Errorf("Old session id is valid: %q", body)
+	}
+	if body, _ := request("/test/session/show", second.Value); body != "admin" {
+		t.Errorf("Fail: show %q", body)
+	}
+
+	if _, cookie := request("/test/session/logout", second.Value); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("Session cookie is not removed: %v", cookie)
+	}
+	if body, _ := request("/test/session/show", second.Value); body != "" {
+		t.Errorf("Destroyed session is valid: %q", body)
+	}
+}
+
+func TestCookieCodec(t *testing.T) {
+	oldKey := []byte("old-secret-key-0123456789")
+	newKey := []byte("new-secret-key-0123456789")
+
+	for _, encrypt := range []bool{false, true} {
+		old, _ := NewCookieCodec(encrypt, oldKey)
+		codec, err := NewCookieCodec(encrypt, newKey, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, _ := codec.Encode("sess", []byte("user=john"), time.Minute)
+		if data, err := codec.Decode("sess", value); string(data) != "user=john" || err != nil {
+			t.Errorf("encrypt=%v: decode %q %v", encrypt, data, err)
+		}
+		raw, _ := base64.RawURLEncoding.DecodeString(value)
+		if encrypted := !strings.Contains(string(raw), "user=john"); encrypted != encrypt {
+			t.Errorf("encrypt=%v: value %q", encrypt, value)
+		}
+
+		// Значение, подписанное старым ключом, принимается после смены ключа
+		oldValue, _ := old.Encode("sess", []byte("old"), time.Minute)
+		if data, err := codec.Decode("sess", oldValue); string(data) != "old" || err != nil {
+			t.Errorf("encrypt=%v: rotated key %q %v", encrypt, data, err)
+		}
+		if _, err := old.Decode("sess", value); err == nil {
+			t.Errorf("encrypt=%v: value signed by unknown key accepted", encrypt)
+		}
+
+		if _, err := codec.Decode("other", value); err == nil {
+			t.Errorf("encrypt=%v: value accepted for another cookie", encrypt)
+		}
+
+		tampered := []byte(value)
+		tampered[3] ^= 1
+		if _, err := codec.Decode("sess", string(tampered)); err == nil {
+			t.Errorf("encrypt=%v: tampered value accepted", encrypt)
+		}
+
+		expired, _ := codec.Encode("sess", []byte("data"), -time.Minute)
+		if _, err := codec.Decode("sess", expired); err == nil {
+			t.Errorf("encrypt=%v: expired value accepted", encrypt)
+		}
+	}
+
+	if _, err := NewCookieCodec(false, []byte("short")); err == nil {
+		t.Error("Short key accepted")
+	}
+}
+
+func (c *SessionController) Blob() error {
+	sess, err := c.Ctx.Session()
+	if err != nil {
+		return err
+	}
+
+	size, _ := strconv.Atoi(c.Ctx.Params.Get("size"))
+	sess.Set("blob", strings.Repeat("x", size))
+	return nil
+}
+
+func TestCookieSession(t *testing.T) {
+	prev := app.sessions
+	defer func() { app.sessions = prev }()
+
+	codec, _ := NewCookieCodec(true, []byte("cookie-session-secret-key"))
+	SetSessionCodec(codec)
+
+	Get("/test/cookie_session/login/:user", RouteOptions{Controller: new(SessionController), Action: "Login"})
+	Get("/test/cookie_session/show", RouteOptions{Controller: new(SessionController), Action: "Show"})
+	Get("/test/cookie_session/blob/:size", RouteOptions{Controller: new(SessionController), Action: "Blob"})
+
+	jar := make(map[string]string)
+	request := func(url string) (string, []*http.Cookie) {
+		var header []string
+		for name, val := range jar {
+			header = append(header, name+"="+val)
+		}
+
+		res := serveRequest(http.MethodGet, url, http.Header{"Cookie": {strings.Join(header, "; ")}}, nil)
+		cookies := res.Result().Cookies()
+		for _, c := range cookies {
+			if c.MaxAge < 0 {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c.Value
+			}
+		}
+		return res.Body.String(), cookies
+	}
+
+	request("/test/cookie_session/login/john")
+	if body, _ := request("/test/cookie_session/show"); body != "john" {
+		t.Errorf("Fail: show %q", body)
+	}
+
+	// Большая сессия делится на части
+	if _, cookies := request("/test/cookie_session/blob/6000"); len(cookies) != 4 || jar["session_id"] != "~3" {
+		t.Fatalf("Session is not chunked: %v", jar)
+	}
+	for _, c := range jar {
+		if len(c) > cookieChunkSize {
+			t.Errorf("Cookie too large: %d", len(c))
+		}
+	}
+	if body, _ := request("/test/cookie_session/show"); body != "john" {
+		t.Errorf("Fail: chunked show %q", body)
+	}
+
+	// Лишние части удаляются, когда сессия уменьшается
+	request("/test/cookie_session/blob/10")
+	if len(jar) != 1 {
+		t.Errorf("Chunks are not removed: %v", jar)
+	}
+	if body, _ := request("/test/cookie_session/show"); body != "john" {
+		t.Errorf("Fail: show %q", body)
+	}
+
+	jar["session_id"] = "forged"
+	if body, _ := request("/test/cookie_session/show"); body != "" {
+		t.Errorf("Forged session accepted: %q", body)
+	}
+}
+
+func (c *SessionController) Save() {
+	c.Flash("success", "Saved")
+	c.Flash("info", "Hello {{.Name}}", map[string]interface{}{"Name": "John"})
+	c.Redirect("/test/flash/page", http.StatusSeeOther)
+}
+
+func (c *SessionController) Page() {
+	c.Render("test_flash", struct{ Title string }{"Page"})
+}
+
+func TestFlash(t *testing.T) {
+	prev := app.sessions
+	defer func() { app.sessions = prev }()
+
+	Get("/test/flash/save", RouteOptions{Controller: new(SessionController), Action: "Save"})
+	Get("/test/flash/page", RouteOptions{Controller: new(SessionController), Action: "Page"})
+
+	// Функции шаблонов работают с моделью любого типа
+	parseTestTemplate("test_flash", `{{.Title}}:{{range flashes}}{{.Kind}}:{{.Message}};{{end}}`)
+
+	for name, sessions := range map[string]*sessionManager{"session": prev, "cookie": nil} {
+		app.sessions = sessions
+
+		jar := make(map[string]string)
+		request := func(url string) *httptest.ResponseRecorder {
+			var header []string
+			for name, val := range jar {
+				header = append(header, name+"="+val)
+			}
+
+			res := serveRequest(http.MethodGet, url, http.Header{"Cookie": {strings.Join(header, "; ")}}, nil)
+			for _, c := range res.Result().Cookies() {
+				if c.MaxAge < 0 {
+					delete(jar, c.Name)
+				} else {
+					jar[c.Name] = c.Value
+				}
+			}
+			return res
+		}
+
+		if res := request("/test/flash/save"); res.Code != http.StatusSeeOther || len(jar) != 1 {
+			t.Errorf("%s: save %d %v", name, res.Code, jar)
+		}
+
+		// Сообщения читаются один раз
+		if body := request("/test/flash/page").Body.String(); body != "Page:success:Saved;info:Hello {{.Name}};" {
+			t.Errorf("%s: page %q", name, body)
+		}
+		if body := request("/test/flash/page").Body.String(); body != "Page:" {
+			t.Errorf("%s: flashes are shown twice %q", name, body)
+		}
+	}
+}
+
+func (c *SessionController) Form() {
+	c.Render("test_csrf", nil)
+}
+
+func (c *SessionController) Submit() {
+	c.Plain("ok")
+}
+
+func TestCSRF(t *testing.T) {
+	prev := app.sessions
+	defer func() { app.sessions = prev }()
+
+	// Cookie токена в режиме double-submit читает JS, HttpOnly по умолчанию к ней не применяется
+	defer SetCookieDefaults(app.cookieDefaults)
+	SetCookieDefaults(CookieDefaults{HttpOnly: true})
+
+	RegisterMiddleware("test_csrf", NewCSRF())
+	Get("/test/csrf", RouteOptions{Controller: new(SessionController), Action: "Form", MiddlewareGroup: "test_csrf"})
+	Post("/test/csrf", RouteOptions{Controller: new(SessionController), Action: "Submit", MiddlewareGroup: "test_csrf"})
+	Post("/test/csrf/hook", RouteOptions{Controller: new(SessionController), Action: "Submit", MiddlewareGroup: "test_csrf", CSRFExempt: true})
+
+	parseTestTemplate("test_csrf", `{{csrfField}}`)
+
+	tokenField := regexp.MustCompile(`^<input type="hidden" name="_csrf" value="([\w-]+)">$`)
+
+	for name, sessions := range map[string]*sessionManager{"session": prev, "double-submit": nil} {
+		app.sessions = sessions
+
+		res := serveRequest(http.MethodGet, "/test/csrf", nil, nil)
+		match := tokenField.FindStringSubmatch(res.Body.String())
+		if match == nil {
+			t.Fatalf("%s: invalid csrf field %q", name, res.Body.String())
+		}
+
+		var cookies []string
+		for _, c := range res.Result().Cookies() {
+			cookies = append(cookies, c.Name+"="+c.Value)
+			if c.Name == "_csrf" && c.HttpOnly {
+				t.Errorf("%s: csrf cookie is HttpOnly", name)
+			}
+		}
+		cookie := strings.Join(cookies, "; ")
+
+		formHeader := http.Header{"Content-Type": {CT_FORM}, "Cookie": {cookie}}
+		for _, td := range []struct {
+			Url    string
+			Header http.Header
+			Body   string
+			Code   int
+		}{
+			{"/test/csrf", formHeader, "_csrf=" + match[1], 200},
+			{"/test/csrf", http.Header{"X-Csrf-Token": {match[1]}, "Cookie": {cookie}, "Origin": {"http://example.com"}}, "", 200},
+			{"/test/csrf", formHeader, "", 403},
+			{"/test/csrf", formHeader, "_csrf=" + match[1][:40], 403},
+			{"/test/csrf", http.Header{"Content-Type": {CT_FORM}}, "_csrf=" + match[1], 403},
+			{"/test/csrf", http.Header{"X-Csrf-Token": {match[1]}, "Cookie": {cookie}, "Origin": {"http://evil.com"}}, "", 403},
+			{"/test/csrf", http.Header{"X-Csrf-Token": {match[1]}, "Cookie": {cookie}, "Referer": {"http://evil.com/page"}}, "", 403},
+			{"/test/csrf/hook", nil, "", 200},
+		} {
+			res := serveRequest(http.MethodPost, td.Url, td.Header, []byte(td.Body))
+			if res.Code != td.Code {
+				t.Errorf("%s: %s %v -> %d %q", name, td.Url, td.Header, res.Code, res.Body.String())
+			}
+		}
+	}
+}
+
+type sessionMiddleware struct {
+	redirect bool
+}
+
+func (m sessionMiddleware) Handler(ctx *Context) bool {
+	sess, err := ctx.Session()
+	if err != nil {
+		return false
+	}
+	sess.Set("user", "guest")
+
+	if m.redirect {
+		http.Redirect(ctx.Response, ctx.Request, "/test/session/show", http.StatusFound)
+	}
+	return false
+}
+
+func TestMiddlewareSession(t *testing.T) {
+
+	RegisterMiddleware("test_session_redirect", sessionMiddleware{redirect: true})
+	RegisterMiddleware("test_session_stop", sessionMiddleware{})
+	Get("/test/session/mw/redirect", RouteOptions{Controller: new(SessionController), Action: "Show", MiddlewareGroup: "test_session_redirect"})
+	Get("/test/session/mw/stop", RouteOptions{Controller: new(SessionController), Action: "Show", MiddlewareGroup: "test_session_stop"})
+
+	for _, url := range []string{"/test/session/mw/redirect", "/test/session/mw/stop"} {
+		res := serveRequest(http.MethodGet, url, nil, nil)
+
+		var cookie *http.Cookie
+		for _, c := range res.Result().Cookies() {
+			if c.Name == "session_id" {
+				cookie = c
+			}
+		}
+		if cookie == nil {
+			t.Errorf("Session is not saved: %s %d %v", url, res.Code, res.Header())
+			continue
+		}
+
+		res = serveRequest(http.MethodGet, "/test/session/show", http.Header{"Cookie": {"session_id=" + cookie.Value}}, nil)
+		if res.Body.String() != "guest" {
+			t.Errorf("Fail: %s %q", url, res.Body.String())
+		}
+	}
+}
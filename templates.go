@@ -0,0 +1,60 @@
+package webgo
+
+import (
+	"html/template"
+	"io"
+	"sync"
+)
+
+// templateSet - копия набора шаблонов приложения, функции flashes, csrfField и csrfToken
+// которой работают с контекстом текущего выполнения. Копии переиспользуются через пул,
+// поэтому набор клонируется только при нехватке свободных копий, а не на каждый запрос.
+// Исходный набор App.templates не выполняется и служит образцом для копий.
+type templateSet struct {
+	tpl *template.Template
+	ctx *Context
+}
+
+// contextFuncs возвращает функции шаблонов, которым нужен контекст запроса
+func contextFuncs(ctx func() *Context) template.FuncMap {
+	return template.FuncMap{
+		"flashes":   func() []Flash { return templateFlashes(ctx()) },
+		"csrfField": func() (template.HTML, error) { return templateCSRFField(ctx()) },
+		"csrfToken": func() (string, error) { return templateCSRFToken(ctx()) },
+	}
+}
+
+func newTemplateSet(templates *template.Template) (*templateSet, error) {
+	tpl, err := templates.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	set := &templateSet{}
+	set.tpl = tpl.Funcs(contextFuncs(func() *Context { return set.ctx }))
+	return set, nil
+}
+
+// resetTemplates сбрасывает копии набора шаблонов, нужно после добавления шаблонов в App.templates
+func (a *App) resetTemplates() {
+	a.templateSets = new(sync.Pool)
+}
+
+// executeTemplate выполняет шаблон с моделью data любого типа. Функции flashes, csrfField
+// и csrfToken работают с контекстом ctx, для писем ctx - nil.
+func (a *App) executeTemplate(w io.Writer, ctx *Context, name string, data interface{}) error {
+	set, ok := a.templateSets.Get().(*templateSet)
+	if !ok {
+		var err error
+		if set, err = newTemplateSet(a.templates); err != nil {
+			return err
+		}
+	}
+
+	set.ctx = ctx
+	err := set.tpl.ExecuteTemplate(w, name, data)
+	set.ctx = nil
+
+	a.templateSets.Put(set)
+	return err
+}
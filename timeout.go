@@ -0,0 +1,92 @@
+package webgo
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeoutWriter пропускает ответ действия, пока не истекло время выполнения.
+// Заголовки копируются в исходный ResponseWriter только при WriteHeader,
+// после deadline запись возвращает http.ErrHandlerTimeout. До вызова start
+// (пока разбирается запрос) время не ограничено.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	h           http.Header
+	deadline    time.Time
+	mu          sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{w: w, h: make(http.Header)}
+}
+
+// start задает момент, после которого ответ действия не принимается
+func (tw *timeoutWriter) start(deadline time.Time) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.deadline = deadline
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.writeHeader(http.StatusOK)
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	return tw.w.Write(data)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if f, ok := tw.w.(http.Flusher); ok && tw.wroteHeader && !tw.timedOut {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	// Действие могло узнать о таймауте раньше, чем сработал timeout()
+	if !tw.wroteHeader && !tw.deadline.IsZero() && !time.Now().Before(tw.deadline) {
+		tw.timedOut = true
+	}
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+}
+
+// timeout запрещает дальнейшую запись. Возвращает true, если ответ еще не начат
+// и его можно заменить.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.timedOut = true
+	return !tw.wroteHeader
+}
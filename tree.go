@@ -0,0 +1,489 @@
+package webgo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxParams - количество параметров, которое Match собирает без выделения памяти
+const maxParams = 16
+
+type (
+	// node - узел сжатого префиксного дерева маршрутов одного метода.
+	// Статические части пути хранятся в path и делят общие префиксы,
+	// параметры (:name) и catch-all (*name) вынесены в отдельные дочерние узлы.
+	node struct {
+		path     string
+		indices  []byte
+		children []*node
+		params   []*node
+		catchAll *node
+		key      string
+		check    *constraint
+		leaf     *leaf
+	}
+	leaf struct {
+		route *Route
+		keys  []string
+	}
+	segment struct {
+		static   string
+		key      string
+		check    *constraint
+		catchAll bool
+		optional bool
+	}
+	// constraint - ограничение значения параметра, например :id<int> или :slug<[a-z0-9-]+>
+	constraint struct {
+		raw   string
+		regex string
+		match func(val string) bool
+	}
+)
+
+// Встроенные ограничения параметров
+var constraints = map[string]*constraint{
+	"int": {
+		regex: `-?[0-9]+`,
+		match: func(val string) bool {
+			_, err := strconv.ParseInt(val, 10, 64)
+			return err == nil
+		},
+	},
+	"uint": {
+		regex: `[0-9]+`,
+		match: func(val string) bool {
+			_, err := strconv.ParseUint(val, 10, 64)
+			return err == nil
+		},
+	},
+	"alpha": {
+		regex: `[A-Za-z]+`,
+	},
+	"alnum": {
+		regex: `[A-Za-z0-9]+`,
+	},
+	"uuid": {
+		regex: `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+		match: _RE_UUID.MatchString,
+	},
+	"date": {
+		regex: `[0-9]{4}-[0-9]{2}-[0-9]{2}`,
+		match: func(val string) bool {
+			_, err := time.Parse(DateLayout, val)
+			return err == nil
+		},
+	},
+}
+
+func init() {
+	for name, c := range constraints {
+		c.raw = name
+		if c.match == nil {
+			c.match = regexp.MustCompile("^(?:" + c.regex + ")$").MatchString
+		}
+	}
+}
+
+// newConstraint возвращает встроенное ограничение по имени либо компилирует регулярное выражение
+func newConstraint(raw string) (*constraint, error) {
+	if c, ok := constraints[raw]; ok {
+		return c, nil
+	}
+
+	regex, err := regexp.Compile("^(?:" + raw + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid param constraint '%s': %s", raw, err)
+	}
+
+	return &constraint{raw, raw, regex.MatchString}, nil
+}
+
+// parsePattern разбивает шаблон маршрута на статические части и параметры.
+// Поддерживаются :name (один сегмент пути), :name? (необязательный сегмент в конце шаблона)
+// и *name (остаток пути вместе со слешами, только в конце шаблона).
+// После имени можно указать ограничение: :id<int>, :slug<[a-z0-9-]+>, :day<date>.
+func parsePattern(pattern string) (segments []segment, err error) {
+	if len(pattern) == 0 || pattern[0] != '/' {
+		err = fmt.Errorf("Route pattern must begin with '/': '%s'.", pattern)
+		return
+	}
+
+	for len(pattern) > 0 {
+		start := strings.IndexAny(pattern, ":*")
+		if start < 0 {
+			segments = append(segments, segment{static: pattern})
+			break
+		}
+
+		if start == 0 || pattern[start-1] != '/' {
+			err = fmt.Errorf("Route param must follow '/': '%s'.", pattern)
+			return
+		}
+
+		segments = append(segments, segment{static: pattern[:start]})
+		pattern = pattern[start:]
+
+		end := paramEnd(pattern)
+
+		match := _RE_KEY_PATTERN.FindStringSubmatch(pattern[:end])
+		if match == nil || len(match[0]) != end {
+			err = fmt.Errorf("Invalid route param: '%s'.", pattern[:end])
+			return
+		}
+
+		seg := segment{
+			key:      match[2],
+			catchAll: match[1] == "*",
+			optional: match[4] == "?",
+		}
+
+		if match[3] != "" {
+			seg.check, err = newConstraint(match[3][1 : len(match[3])-1])
+			if err != nil {
+				return
+			}
+		}
+
+		if seg.catchAll && (seg.optional || end != len(pattern)) {
+			err = fmt.Errorf("Catch-all param must be the last one: '%s'.", pattern)
+			return
+		}
+
+		segments = append(segments, seg)
+		pattern = pattern[end:]
+	}
+
+	// Необязательные параметры допустимы только в хвосте шаблона
+	for i, seg := range segments {
+		if !seg.optional {
+			continue
+		}
+
+		for _, next := range segments[i+1:] {
+			if !next.optional && next.static != "/" {
+				err = fmt.Errorf("Optional params must be trailing: '%s'.", seg.key)
+				return
+			}
+		}
+
+		if !segments[len(segments)-1].optional {
+			err = fmt.Errorf("Optional params must be trailing: '%s'.", seg.key)
+			return
+		}
+		break
+	}
+
+	return
+}
+
+// paramEnd возвращает конец параметра в начале шаблона с учетом слешей внутри ограничения
+func paramEnd(pattern string) int {
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case '/':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(pattern)
+}
+
+// expandOptional возвращает варианты шаблона без необязательных параметров.
+// Для /archive/:year/:month? это /archive/:year/:month и /archive/:year,
+// для /a/:b?/:c? - /a/:b/:c, /a/:b и /a.
+func expandOptional(segments []segment) (variants [][]segment) {
+	variants = append(variants, segments)
+
+	for i := len(segments) - 1; i > 0; i-- {
+		// Слеш между необязательными параметрами отрезается вместе с параметром перед ним
+		if segments[i].static == "/" && segments[i-1].optional {
+			continue
+		}
+		if !segments[i].optional {
+			break
+		}
+
+		// Отрезаем слеш перед параметром
+		variant := append([]segment(nil), segments[:i]...)
+		last := &variant[len(variant)-1]
+		last.static = last.static[:len(last.static)-1]
+
+		if last.static == "" {
+			if len(variant) == 1 {
+				last.static = "/"
+			} else {
+				variant = variant[:len(variant)-1]
+			}
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return
+}
+
+// patternRegex строит регулярное выражение, эквивалентное шаблону
+func patternRegex(segments []segment) string {
+	var buf strings.Builder
+
+	buf.WriteByte('^')
+	for i, seg := range segments {
+		value := `[^\/]+`
+		if seg.catchAll {
+			value = `.*`
+		}
+		if seg.check != nil {
+			value = "(?:" + seg.check.regex + ")"
+		}
+
+		switch {
+		case seg.optional:
+			buf.WriteString(`(?:\/(` + value + `))?`)
+		case seg.key != "":
+			buf.WriteString(`(` + value + `)`)
+		default:
+			static := seg.static
+			if i+1 < len(segments) && segments[i+1].optional {
+				static = static[:len(static)-1]
+			}
+			buf.WriteString(regexp.QuoteMeta(static))
+		}
+	}
+	buf.WriteString(`\/?$`)
+
+	return buf.String()
+}
+
+// segmentKeys возвращает имена параметров шаблона по порядку
+func segmentKeys(segments []segment) []string {
+	keys := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		if seg.key != "" {
+			keys = append(keys, seg.key)
+		}
+	}
+	return keys
+}
+
+// insert добавляет маршрут в дерево
+func (n *node) insert(segments []segment, l *leaf) error {
+	for _, seg := range segments {
+		switch {
+		case seg.catchAll:
+			if n.catchAll == nil {
+				n.catchAll = &node{key: seg.key, check: seg.check}
+			} else if n.catchAll.key != seg.key || n.catchAll.check.String() != seg.check.String() {
+				return fmt.Errorf("Catch-all param conflicts with '*%s'.", n.catchAll.key)
+			}
+			n = n.catchAll
+		case seg.key != "":
+			n = n.paramChild(seg.key, seg.check)
+		default:
+			n = n.staticChild(seg.static)
+		}
+	}
+
+	if n.leaf != nil {
+		return fmt.Errorf("Route conflicts with '%s'.", n.leaf.route.Pattern)
+	}
+
+	n.leaf = l
+	return nil
+}
+
+// conflict проверяет, что маршрут можно добавить в дерево, не изменяя его
+func (n *node) conflict(segments []segment) error {
+	for _, seg := range segments {
+		switch {
+		case seg.catchAll:
+			if n.catchAll == nil {
+				return nil
+			}
+			if n.catchAll.key != seg.key || n.catchAll.check.String() != seg.check.String() {
+				return fmt.Errorf("Catch-all param conflicts with '*%s'.", n.catchAll.key)
+			}
+			n = n.catchAll
+		case seg.key != "":
+			var next *node
+			for _, child := range n.params {
+				if child.key == seg.key && child.check.String() == seg.check.String() {
+					next = child
+					break
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+		default:
+			for path := seg.static; len(path) > 0; {
+				i := n.indexOf(path[0])
+				if i < 0 {
+					return nil
+				}
+
+				child := n.children[i]
+				common := commonPrefix(child.path, path)
+				if common < len(child.path) {
+					return nil
+				}
+
+				n = child
+				path = path[common:]
+			}
+		}
+	}
+
+	if n.leaf != nil {
+		return fmt.Errorf("Route conflicts with '%s'.", n.leaf.route.Pattern)
+	}
+	return nil
+}
+
+// staticChild возвращает узел, соответствующий статическому пути, разбивая существующие узлы при необходимости
+func (n *node) staticChild(path string) *node {
+	for len(path) > 0 {
+		i := n.indexOf(path[0])
+		if i < 0 {
+			child := &node{path: path}
+			n.indices = append(n.indices, path[0])
+			n.children = append(n.children, child)
+			return child
+		}
+
+		child := n.children[i]
+		common := commonPrefix(child.path, path)
+
+		if common < len(child.path) {
+			// Разделяем узел по общему префиксу
+			tail := *child
+			tail.path = child.path[common:]
+
+			*child = node{
+				path:     child.path[:common],
+				indices:  []byte{tail.path[0]},
+				children: []*node{&tail},
+			}
+		}
+
+		n = child
+		path = path[common:]
+	}
+
+	return n
+}
+
+// paramChild возвращает узел параметра. Параметры с ограничениями проверяются раньше
+// параметров без ограничений, поэтому /orders/:id<int> и /orders/:slug не перекрывают друг друга.
+func (n *node) paramChild(key string, check *constraint) *node {
+	pos := len(n.params)
+	for i, child := range n.params {
+		if child.key == key && child.check.String() == check.String() {
+			return child
+		}
+		if check != nil && child.check == nil && pos > i {
+			pos = i
+		}
+	}
+
+	child := &node{key: key, check: check}
+	n.params = append(n.params, nil)
+	copy(n.params[pos+1:], n.params[pos:])
+	n.params[pos] = child
+	return child
+}
+
+func (c *constraint) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.raw
+}
+
+func (n *node) indexOf(c byte) int {
+	for i := range n.indices {
+		if n.indices[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup ищет маршрут для пути. Статические узлы проверяются раньше параметров,
+// поэтому /users/new всегда выигрывает у /users/:id независимо от порядка регистрации.
+// Значения параметров дописываются в values по порядку следования в пути.
+// При fold статические части сравниваются без учета регистра.
+func (n *node) lookup(path string, values []string, fold bool) (*leaf, []string) {
+	if len(path) == 0 {
+		if n.leaf == nil && n.catchAll != nil && (n.catchAll.check == nil || n.catchAll.check.match(path)) {
+			return n.catchAll.leaf, append(values, path)
+		}
+		return n.leaf, values
+	}
+
+	if !fold {
+		if i := n.indexOf(path[0]); i >= 0 {
+			child := n.children[i]
+			if strings.HasPrefix(path, child.path) {
+				if l, vals := child.lookup(path[len(child.path):], values, fold); l != nil {
+					return l, vals
+				}
+			}
+		}
+	} else {
+		for _, child := range n.children {
+			if len(path) >= len(child.path) && strings.EqualFold(path[:len(child.path)], child.path) {
+				if l, vals := child.lookup(path[len(child.path):], values, fold); l != nil {
+					return l, vals
+				}
+			}
+		}
+	}
+
+	if len(n.params) > 0 {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+
+		if end > 0 {
+			for _, child := range n.params {
+				if child.check != nil && !child.check.match(path[:end]) {
+					continue
+				}
+				if l, vals := child.lookup(path[end:], append(values, path[:end]), fold); l != nil {
+					return l, vals
+				}
+			}
+		}
+	}
+
+	if n.catchAll != nil && (n.catchAll.check == nil || n.catchAll.check.match(path)) {
+		return n.catchAll.leaf, append(values, path)
+	}
+
+	return nil, values
+}
+
+func commonPrefix(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,91 @@
+package webgo
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URL строит путь именованного маршрута. Параметры передаются парами имя-значение:
+//
+//	router.URL("user", "id", 15) // /users/15
+//
+// Отсутствующий обязательный или лишний параметр приводит к ошибке.
+func (r *Router) URL(name string, params ...interface{}) (string, error) {
+	r.internalInit()
+
+	route, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("Not found route: '%s'.", name)
+	}
+
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("Odd number of params for route '%s'.", name)
+	}
+
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("Invalid param name for route '%s': %v", name, params[i])
+		}
+		values[key] = fmt.Sprint(params[i+1])
+	}
+
+	var buf bytes.Buffer
+	segments := route.segments
+
+	for i, seg := range segments {
+		if seg.key == "" {
+			// Слеш перед пропущенным необязательным параметром не нужен
+			if i+1 < len(segments) && segments[i+1].optional {
+				if _, ok := values[segments[i+1].key]; !ok {
+					static := strings.TrimSuffix(seg.static, "/")
+					if static == "" && buf.Len() == 0 {
+						static = "/"
+					}
+					buf.WriteString(static)
+					break
+				}
+			}
+
+			buf.WriteString(seg.static)
+			continue
+		}
+
+		val, ok := values[seg.key]
+		if !ok {
+			return "", fmt.Errorf("Missing param '%s' for route '%s'.", seg.key, name)
+		}
+		delete(values, seg.key)
+
+		if seg.check != nil && !seg.check.match(val) {
+			return "", fmt.Errorf("Invalid value '%s' for param '%s' of route '%s'.", val, seg.key, name)
+		}
+
+		if seg.catchAll {
+			parts := strings.Split(val, "/")
+			for i := range parts {
+				parts[i] = url.PathEscape(parts[i])
+			}
+			buf.WriteString(strings.Join(parts, "/"))
+		} else {
+			buf.WriteString(url.PathEscape(val))
+		}
+	}
+
+	for key := range values {
+		return "", fmt.Errorf("Unknown param '%s' for route '%s'.", key, name)
+	}
+
+	return buf.String(), nil
+}
+
+func (a *App) URL(name string, params ...interface{}) (string, error) {
+	return a.router.URL(name, params...)
+}
+
+func URL(name string, params ...interface{}) (string, error) {
+	return app.URL(name, params...)
+}
@@ -0,0 +1,369 @@
+package webgo
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/IntelliQru/i18n"
+)
+
+type (
+	// FieldError - ошибка значения одного поля: правило, его параметр и переведенное сообщение
+	FieldError struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule"`
+		Param   string `json:"param,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// ValidationErrors - ошибки всех полей структуры, возвращается из Context.ValidateSchema
+	ValidationErrors []FieldError
+
+	// ValidatorFunc проверяет значение поля, param - параметр правила из тега (min=3 -> "3").
+	// Для указателей передается значение, на которое они указывают. Нулевые значения (0, "", пустой срез)
+	// проверяются всеми правилами, если перед ними нет omitempty; nil-указатель проверяет только required.
+	ValidatorFunc func(value reflect.Value, param string) bool
+
+	// validation - правила полей структуры, разобранные из тегов validate
+	validation struct {
+		fields []validationField
+	}
+	validationField struct {
+		index  int
+		name   string
+		rules  []rule
+		nested *validation
+	}
+	rule struct {
+		name  string
+		param string
+		fn    ValidatorFunc
+	}
+	validationEntry struct {
+		validation *validation
+		err        error
+	}
+)
+
+// Сообщения по умолчанию, если для правила нет перевода с идентификатором validation_<правило>.
+// В перевод передаются Field, Param и Value.
+var validationMessages = map[string]string{
+	"type":     "Invalid value '{{.Value}}' for '{{.Field}}', must be {{.Param}}",
+	"required": "Field '{{.Field}}' is required",
+	"min":      "Field '{{.Field}}' must be at least {{.Param}}",
+	"max":      "Field '{{.Field}}' must be at most {{.Param}}",
+	"email":    "Field '{{.Field}}' must be a valid email",
+	"oneof":    "Field '{{.Field}}' must be one of: {{.Param}}",
+	"regex":    "Field '{{.Field}}' has invalid format",
+	"unknown":  "Unknown field '{{.Field}}'",
+	"":         "Field '{{.Field}}' is invalid",
+}
+
+var validators = map[string]ValidatorFunc{
+	"required": func(value reflect.Value, param string) bool {
+		return !isEmpty(value)
+	},
+	// omitempty пропускает остальные правила для пустого значения, обрабатывается в validation.validate
+	"omitempty": func(value reflect.Value, param string) bool {
+		return true
+	},
+	"min": func(value reflect.Value, param string) bool {
+		size, ok := valueSize(value)
+		limit, err := strconv.ParseFloat(param, 64)
+		return ok && err == nil && size >= limit
+	},
+	"max": func(value reflect.Value, param string) bool {
+		size, ok := valueSize(value)
+		limit, err := strconv.ParseFloat(param, 64)
+		return ok && err == nil && size <= limit
+	},
+	"email": func(value reflect.Value, param string) bool {
+		addr, err := mail.ParseAddress(fmt.Sprint(value.Interface()))
+		return err == nil && addr.Name == "" && addr.Address == fmt.Sprint(value.Interface())
+	},
+	"oneof": func(value reflect.Value, param string) bool {
+		str := fmt.Sprint(value.Interface())
+		for _, item := range strings.Fields(param) {
+			if item == str {
+				return true
+			}
+		}
+		return false
+	},
+	"regex": func(value reflect.Value, param string) bool {
+		re, err := compileRegex(param)
+		return err == nil && re.MatchString(fmt.Sprint(value.Interface()))
+	},
+}
+
+var (
+	validations sync.Map
+	regexps     sync.Map
+)
+
+// RegisterValidator добавляет правило для тега validate, например validate:"phone".
+// Правила нужно регистрировать до добавления маршрутов и первой проверки.
+func RegisterValidator(name string, fn ValidatorFunc) {
+	validators[name] = fn
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// newFieldError создает ошибку поля с сообщением на языке T
+func newFieldError(T i18n.TFuncHandler, field, rule, param, value string) FieldError {
+	args := map[string]interface{}{"Field": field, "Param": param, "Value": value}
+
+	id := "validation_" + rule
+	message := T(id, args)
+	if message == id || message == "" {
+		tpl, ok := validationMessages[rule]
+		if !ok {
+			tpl = validationMessages[""]
+		}
+		message = formatMessage(tpl, args)
+	}
+
+	return FieldError{Field: field, Rule: rule, Param: param, Message: message}
+}
+
+// formatMessage подставляет аргументы в сообщение вида "Field '{{.Field}}'"
+func formatMessage(tpl string, args map[string]interface{}) string {
+	for key, val := range args {
+		tpl = strings.Replace(tpl, "{{."+key+"}}", fmt.Sprint(val), -1)
+	}
+	return tpl
+}
+
+// validationOf возвращает правила для типа структуры
+func validationOf(typ reflect.Type) (*validation, error) {
+	if entry, ok := validations.Load(typ); ok {
+		return entry.(*validationEntry).validation, entry.(*validationEntry).err
+	}
+
+	v, err := newValidation(typ, map[reflect.Type]bool{})
+	validations.Store(typ, &validationEntry{v, err})
+	return v, err
+}
+
+// newValidation разбирает теги validate. Имя поля в ошибках берется из тегов привязки
+// (form, json, query, header, cookie, path), для вложенных структур - через точку.
+func newValidation(typ reflect.Type, building map[reflect.Type]bool) (*validation, error) {
+	building[typ] = true
+	defer delete(building, typ)
+
+	v := &validation{}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		f := validationField{index: i, name: fieldName(field)}
+
+		rules, err := parseRules(field.Tag.Get("validate"))
+		if err != nil {
+			return nil, fmt.Errorf("Field '%s': %s", field.Name, err)
+		}
+		f.rules = rules
+
+		ftype := field.Type
+		if ftype.Kind() == reflect.Ptr {
+			ftype = ftype.Elem()
+		}
+		if ftype.Kind() == reflect.Struct && !isScalar(ftype) && !building[ftype] {
+			nested, err := newValidation(ftype, building)
+			if err != nil {
+				return nil, err
+			}
+			if len(nested.fields) > 0 {
+				f.nested = nested
+			}
+		}
+
+		if field.Anonymous {
+			f.name = ""
+		}
+
+		if len(f.rules) > 0 || f.nested != nil {
+			v.fields = append(v.fields, f)
+		}
+	}
+
+	return v, nil
+}
+
+// parseRules разбирает тег validate:"required,min=3,regex=^[a-z,]+$".
+// Правило regex забирает остаток тега, поэтому должно быть последним.
+func parseRules(tag string) (rules []rule, err error) {
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "regex=") {
+			item, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			item, tag = tag[:i], tag[i+1:]
+		} else {
+			item, tag = tag, ""
+		}
+
+		r := rule{name: item}
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			r.name, r.param = item[:i], item[i+1:]
+		}
+
+		fn, ok := validators[r.name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown validation rule '%s'", r.name)
+		}
+		r.fn = fn
+
+		switch r.name {
+		case "min", "max":
+			if _, err = strconv.ParseFloat(r.param, 64); err != nil {
+				return nil, fmt.Errorf("Invalid param '%s' of rule '%s'", r.param, r.name)
+			}
+		case "regex":
+			if _, err = compileRegex(r.param); err != nil {
+				return nil, err
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+// validate проверяет все поля и дописывает ошибки в errs, поля с ошибками привязки пропускаются
+func (v *validation) validate(T i18n.TFuncHandler, val reflect.Value, prefix string, errs ValidationErrors) ValidationErrors {
+	for _, f := range v.fields {
+		field := val.Field(f.index)
+		key := joinKey(prefix, f.name)
+
+		if errs.has(key) {
+			continue
+		}
+
+		for _, r := range f.rules {
+			if r.name == "omitempty" {
+				if isEmpty(field) {
+					break
+				}
+				continue
+			}
+
+			// nil-указатель означает, что значение не передано, его проверяет только required.
+			// Нулевое значение без omitempty проверяется всеми правилами.
+			value := field
+			if r.name != "required" {
+				if (field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface) && field.IsNil() {
+					continue
+				}
+				value = reflect.Indirect(field)
+			}
+
+			if !r.fn(value, r.param) {
+				errs = append(errs, newFieldError(T, key, r.name, r.param, valueString(value)))
+				break
+			}
+		}
+
+		if f.nested != nil {
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					continue
+				}
+				field = field.Elem()
+			}
+			errs = f.nested.validate(T, field, key, errs)
+		}
+	}
+
+	return errs
+}
+
+func (e ValidationErrors) has(field string) bool {
+	for i := range e {
+		if e[i].Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName возвращает имя поля для сообщений об ошибках
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"form", "json", "query", "header", "cookie", "path"} {
+		if name := tagName(field.Tag.Get(tag)); name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func valueString(value reflect.Value) string {
+	if !value.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// isEmpty проверяет, что значение нулевое: пустая строка, 0, nil или пустой срез
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return value.Len() == 0
+	case reflect.Invalid:
+		return true
+	}
+	return value.IsZero()
+}
+
+// valueSize возвращает длину строки в символах, длину среза или значение числа
+func valueSize(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return 0, false
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid validation regex '%s': %s", expr, err)
+	}
+
+	regexps.Store(expr, re)
+	return re, nil
+}
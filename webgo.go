@@ -6,36 +6,45 @@ import (
 	"github.com/mixapp/mail"
 
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"mime"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/IntelliQru/i18n"
 )
 
 type App struct {
-	router        Router
-	definitions   Definitions
-	templates     *template.Template
-	staticDir     string
-	workDir       string
-	tmpDir        string
-	langDir       string
-	maxBodyLength int64
-	defaultLang   string
+	router         Router
+	definitions    Definitions
+	templates      *template.Template
+	templateSets   *sync.Pool
+	staticDir      string
+	workDir        string
+	tmpDir         string
+	langDir        string
+	maxBodyLength  int64
+	defaultLang    string
+	timeout        time.Duration
+	trailingSlash  SlashPolicy
+	cleanPath      bool
+	errorTemplate  string
+	errorPages     map[int]string
+	errorHandler   ErrorHandlerFunc
+	panicHandler   PanicHandler
+	sessions       *sessionManager
+	cookieDefaults CookieDefaults
 }
 
 const (
@@ -81,7 +90,9 @@ func init() {
 	}
 
 	// Init application
-	templates := template.New("template")
+	templates := template.New("template").Funcs(template.FuncMap{
+		"url": URL,
+	}).Funcs(contextFuncs(func() *Context { return nil }))
 	filepath.Walk("templates", func(pathToFile string, info os.FileInfo, err error) error {
 
 		if path.Ext(pathToFile) == ".html" {
@@ -96,6 +107,7 @@ func init() {
 		Handlers: make(map[string][]MiddlewareInterface),
 	}
 	app.templates = templates
+	app.resetTemplates()
 	app.staticDir = "public"
 	app.defaultLang = "en-US"
 
@@ -107,6 +119,25 @@ func init() {
 	app.langDir = path.Join(app.workDir, "i18n")
 	app.tmpDir = path.Join(app.workDir, "tmp")
 	app.maxBodyLength = 131072
+	app.errorTemplate = CFG.Str("errorTemplate")
+	app.errorPages = make(map[int]string)
+
+	app.timeout = 2 * time.Second
+	if CFG.Int("timeout") != 0 {
+		app.timeout = time.Duration(CFG.Int("timeout")) * time.Second
+	}
+
+	app.cleanPath = true
+	if len(CFG.Str("cleanPath")) > 0 {
+		app.cleanPath = cfgBool("cleanPath")
+	}
+
+	policy, ok := slashPolicies[CFG.Str("trailingSlash")]
+	if !ok && len(CFG.Str("trailingSlash")) > 0 {
+		LOGGER.Fatal(fmt.Errorf("Unknown trailingSlash policy: '%s'.", CFG.Str("trailingSlash")))
+	}
+	app.SetTrailingSlash(policy)
+	app.SetCaseInsensitive(cfgBool("caseInsensitive"))
 
 	_, err := os.Stat(app.tmpDir)
 	if os.IsNotExist(err) {
@@ -116,6 +147,16 @@ func init() {
 		}
 	}
 
+	app.cookieDefaults, err = newCookieDefaults()
+	if err != nil {
+		LOGGER.Fatal(err)
+	}
+
+	app.sessions, err = newSessionManager()
+	if err != nil {
+		LOGGER.Fatal(err)
+	}
+
 	_, err = os.Stat(fmt.Sprint(app.langDir))
 	if os.IsNotExist(err) {
 		err = os.Mkdir(app.langDir, os.ModePerm)
@@ -135,6 +176,15 @@ func init() {
 	})
 }
 
+// cfgBool читает логический параметр конфигурации
+func cfgBool(key string) bool {
+	switch strings.ToLower(CFG.Str(key)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
 func parseRequest(ctx *Context, limit int64) (errorCode int, err error) {
 	var body []byte
 
@@ -149,7 +199,7 @@ func parseRequest(ctx *Context, limit int64) (errorCode int, err error) {
 
 	err = ctx.Request.ParseForm()
 	if err != nil {
-		errorCode = 400
+		errorCode = bodyErrorCode(err)
 		return
 	}
 
@@ -159,25 +209,6 @@ func parseRequest(ctx *Context, limit int64) (errorCode int, err error) {
 	}
 
 	switch ctx.ContentType {
-	case CT_JSON:
-		defer ctx.Request.Body.Close()
-		body, err = ioutil.ReadAll(ctx.Request.Body)
-		if err != nil {
-			errorCode = 400
-			return
-		}
-
-		var data interface{}
-		err = json.Unmarshal(body, &data)
-		if err != nil {
-			errorCode = 400
-			return
-		}
-
-		ctx._Body = body
-		ctx.Body = data.(map[string]interface{})
-		return
-
 	case CT_FORM:
 		err = ctx.Request.ParseForm()
 		if err != nil {
@@ -189,50 +220,50 @@ func parseRequest(ctx *Context, limit int64) (errorCode int, err error) {
 		//ctx._Body = []byte(ctx.Request.Form.Encode())
 
 	case CT_MULTIPART:
-		err = ctx.Request.ParseMultipartForm(limit)
+		// В потоковом режиме части читает действие через Context.NextPart
+		if ctx.options != nil && ctx.options.StreamMultipart {
+			return
+		}
+
+		errorCode, err = parseMultipart(ctx)
 		if err != nil {
-			//TODO: 400 or 413
-			errorCode = 400
 			return
 		}
 
-		for _, fheaders := range ctx.Request.MultipartForm.File {
-			for _, hdr := range fheaders {
-				var infile multipart.File
-				if infile, err = hdr.Open(); nil != err {
-					LOGGER.Error(err)
-					errorCode = 500
-					return
-				}
-
-				var outfile *os.File
-				if outfile, err = ioutil.TempFile(app.tmpDir, "tmp_"); err != nil {
-					LOGGER.Error(err)
-					errorCode = 500
-					return
-				}
-				// 32K buffer copy
-				var written int64
-				if written, err = io.Copy(outfile, infile); nil != err {
-					LOGGER.Error(err)
-					errorCode = 500
-					return
-				}
-
-				tmpFile := File{
-					Path: outfile.Name(),
-					Name: hdr.Filename,
-					Size: int64(written),
-				}
-
-				ctx.Files = append(ctx.Files, tmpFile)
+	default:
+		// Остальные типы содержимого разбираются зарегистрированными декодерами
+		ctx.decoder = lookupDecoder(ctx.ContentType)
+		if ctx.decoder == nil {
+			if ctx.Request.ContentLength > 0 {
+				err = errors.New("Bad Request")
+				errorCode = 400
 			}
+			return
 		}
 
-	default:
-		if ctx.Request.ContentLength > 0 {
-			err = errors.New("Bad Request")
+		// Потоковое тело читает действие
+		if isStream(ctx.decoder) {
+			return
+		}
+
+		defer ctx.Request.Body.Close()
+		body, err = ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			errorCode = bodyErrorCode(err)
+			return
+		}
+		ctx._Body = body
+
+		// Тело разбирается один раз декодером, результат доступен через Data, объект попадает и в Body.
+		// Тело может быть массивом, строкой или числом, такие значения доступны только через Data
+		var data interface{}
+		data, err = ctx.Data()
+		if err != nil {
 			errorCode = 400
+			return
+		}
+		if obj, ok := data.(map[string]interface{}); ok {
+			ctx.Body = obj
 		}
 
 		return
@@ -251,14 +282,13 @@ func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//	LOGGER.Fatal("don't support CloseNotifier")
 	//}
 
-	var vc reflect.Value
-	var Action reflect.Value
+	defer a.recoverRequest(w, r)
 
 	method := r.Method
-	path := r.URL.Path
 
-	if len(path) > 1 && strings.HasSuffix(path, "/") {
-		http.Redirect(w, r, path[:len(path)-1], 301)
+	path, redirect := a.resolvePath(r.Host, r.URL.Path)
+	if redirect {
+		redirectPath(w, r, path)
 		return
 	}
 
@@ -270,68 +300,172 @@ func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}*/
 
-	route := a.router.Match(method, path)
+	route := a.router.MatchHost(method, r.Host, path)
+
+	// HEAD обслуживается GET-маршрутом без тела ответа
+	if route == nil && method == http.MethodHead {
+		route = a.router.MatchHost(http.MethodGet, r.Host, path)
+		if route != nil {
+			w = &headResponseWriter{w}
+		}
+	}
+
 	if route == nil {
-		http.Error(w, "", 404)
+		allowed := a.router.AllowedHost(r.Host, path)
+		ctx := newContext(w, r, nil)
+		ctx.detectLang()
+
+		if len(allowed) == 0 {
+			// Перенаправление на путь с другим завершающим слешем, если маршрут есть для него
+			if alt, ok := a.slashAlternative(r.Host, path); ok {
+				redirectPath(w, r, alt)
+				return
+			}
+
+			a.handleError(ctx, &HTTPError{Code: http.StatusNotFound})
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+		// Автоматический ответ на OPTIONS, если маршрут не задан явно
+		if method == http.MethodOptions {
+			w.WriteHeader(204)
+			return
+		}
+
+		a.handleError(ctx, &HTTPError{Code: http.StatusMethodNotAllowed})
 		return
 	}
 
-	if route.Options.Timeout == 0 {
-		route.Options.Timeout = 2
+	if route.Options.Handler != nil {
+		a.serveHandler(w, r, route)
+		return
 	}
-	//timeout := time.After(route.Options.Timeout * time.Second)
-	//done := make(chan bool)
 
-	vc = reflect.New(route.ControllerType)
-	Action = vc.MethodByName(route.Options.Action)
+	a.serveController(w, r, route)
+}
 
-	var err error
-	ctx := Context{
-		Action:   route.Options.Action,
-		Response: w,
-		Request:  r,
-		Query:    make(map[string]interface{}),
-		Body:     make(map[string]interface{}),
-		Params:   route.Params,
-		Method:   method,
-		Lang:     app.defaultLang,
+// serveController выполняет действие контроллера с ограничением времени маршрута.
+// Время отсчитывается после чтения тела запроса, медленная загрузка тела в него не входит.
+func (a *App) serveController(w http.ResponseWriter, r *http.Request, route *Match) {
+	timeout := a.timeout
+	if route.Options.Timeout != 0 {
+		timeout = route.Options.Timeout
+	}
+
+	// Для websocket соединение перехватывается, ограничение времени не применяется
+	if timeout < 0 || strings.ToLower(r.Header.Get("Upgrade")) == "websocket" {
+		a.runController(w, r, route, nil)
+		return
 	}
+
+	tw := newTimeoutWriter(w)
+
+	started := make(chan context.Context, 1)
+	done := make(chan struct{})
+	panicChan := make(chan interface{}, 1)
+
+	go func() {
+		var cancel context.CancelFunc
+		defer func() {
+			if cancel != nil {
+				cancel()
+			}
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+
+		a.runController(tw, r, route, func(ctx *Context) {
+			var reqCtx context.Context
+			reqCtx, cancel = context.WithTimeout(ctx.Request.Context(), timeout)
+			ctx.Request = ctx.Request.WithContext(reqCtx)
+
+			deadline, _ := reqCtx.Deadline()
+			tw.start(deadline)
+			started <- reqCtx
+		})
+		close(done)
+	}()
+
+	// Разбор запроса
+	var reqCtx context.Context
+	select {
+	case <-done:
+		return
+	case p := <-panicChan:
+		panic(p)
+	case <-r.Context().Done():
+		// Клиент отключился, отвечать некому
+		tw.timeout()
+		return
+	case reqCtx = <-started:
+	}
+
+	select {
+	case <-done:
+	case p := <-panicChan:
+		panic(p)
+	case <-reqCtx.Done():
+		// Клиент отключился, отвечать некому
+		if reqCtx.Err() != context.DeadlineExceeded {
+			tw.timeout()
+			return
+		}
+
+		LOGGER.Error(fmt.Errorf("Action timeout %s: %s.%s (%s %s)", timeout, route.ControllerType, route.Options.Action, r.Method, r.URL.Path))
+
+		if tw.timeout() {
+			a.runTimeout(w, r, route)
+		}
+	}
+}
+
+// runTimeout отдает ответ 504 новым экземпляром контроллера маршрута,
+// так как прерванное действие может продолжать работать со своим контекстом
+func (a *App) runTimeout(w http.ResponseWriter, r *http.Request, route *Match) {
+	controller, ok := reflect.New(route.ControllerType).Interface().(ControllerInterface)
+	if !ok {
+		http.Error(w, "", 504)
+		return
+	}
+
+	controller.Init(newContext(w, r, route))
+	controller.Error504("")
+	controller.exec()
+}
+
+// runController разбирает запрос и выполняет действие контроллера.
+// start, если задан, вызывается после разбора запроса перед вызовом контроллера.
+func (a *App) runController(w http.ResponseWriter, r *http.Request, route *Match, start func(ctx *Context)) {
+	vc := reflect.New(route.ControllerType)
+	Action := vc.Method(route.route.action.index)
+
+	var err error
+	ctx := newContext(w, r, route)
+	defer a.recoverPanic(ctx, route)
+
+	// Временные файлы удаляются при любом завершении, в том числе при ошибке разбора,
+	// отказе Prepare или middleware
+	defer ctx.removeFiles()
+
 	ctx.ContentType = ctx.Request.Header.Get("Content-Type")
 	ctx.ContentType, _, err = mime.ParseMediaType(ctx.ContentType)
 
 	if ctx.Request.ContentLength > 0 && err != nil {
-		http.Error(w, "", 400)
+		a.handleError(ctx, &HTTPError{Code: http.StatusBadRequest, Err: err})
 		return
 	}
 
 	// Определение языка
 	if route.Options.I18n {
-		acceptLang := ctx.Request.Header.Get("Accept-Language")
-		cookieLang := ctx.GetCookie("lang")
-
-		if len(cookieLang) != 0 && i18n.CheckLang(cookieLang) {
-			ctx.Lang = cookieLang
-		} else {
-			// Находим поддерживаемый язык из Accept-Language
-			start := 0
-			for end, chr := range acceptLang {
-				switch chr {
-				case ',', ';':
-					tag := strings.TrimSpace(acceptLang[start:end])
-					if i18n.CheckLang(tag) {
-						ctx.Lang = tag
-						break
-					}
-					start = end + 1
-				}
-			}
-		}
+		ctx.detectLang()
 	}
 
 	Controller, ok := vc.Interface().(ControllerInterface)
 	if !ok {
-		LOGGER.Error(errors.New("controller is not ControllerInterface"))
-		http.Error(w, "", 500)
+		a.handleError(ctx, &HTTPError{Code: http.StatusInternalServerError, Err: errors.New("controller is not ControllerInterface")})
 		return
 	}
 
@@ -341,55 +475,69 @@ func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		maxBodyLength = route.Options.BodyLength
 	}
 
-	code, err := parseRequest(&ctx, maxBodyLength)
+	code, err := parseRequest(ctx, maxBodyLength)
 	if err != nil {
-		http.Error(w, "", code)
+		a.handleError(ctx, toHTTPError(err, code))
 		return
 	}
 
+	if start != nil {
+		start(ctx)
+	}
+
+	// Сообщения и сессия сохраняются перед первой записью ответа, на любом пути выполнения
+	sw := a.wrapResponse(ctx)
+
 	// Инициализация контекста
-	Controller.Init(&ctx)
+	Controller.Init(ctx)
 
 	// Запуск предобработчика
 	if !Controller.Prepare() {
+		a.commitSession(ctx)
 		Controller.exec()
 		return
 	}
 
 	// Запуск цепочки middleware
-	if !app.definitions.Run(route.Options.MiddlewareGroup, &ctx) {
+	if !app.definitions.Run(route.Options.MiddlewareGroup, ctx) {
+		sw.finish()
 		return
 	}
 
-	in := make([]reflect.Value, 0)
-	Action.Call(in)
-	//go func () {
-	//	in := make([]reflect.Value, 0)
-	//	Action.Call(in)
-	//	done <- true
-	//}()
+	// Параметры действия, ошибка привязки отдается как 400 без вызова действия
+	in, err := route.route.action.bind(ctx)
+	if err != nil {
+		ctx.error = toHTTPError(err, http.StatusBadRequest)
+	} else {
+		out := Action.Call(in)
+
+		// Ошибка действия отдается через обработчик ошибок в exec
+		if route.route.action.returnsError && !out[0].IsNil() {
+			ctx.error = out[0].Interface().(error)
+		}
+	}
 
 	// Запуск постобработчика
 
 	Controller.Finish()
 
-	if ctx.ContentType == "multipart/form-data" {
-		err = ctx.Files.RemoveAll()
-		if err != nil {
-			LOGGER.Error(err)
-		}
-
-		err = ctx.Request.MultipartForm.RemoveAll()
-		if err != nil {
-			LOGGER.Error(err)
-		}
-	}
+	// Сообщения и сессия сохраняются до отправки ответа, чтобы успеть передать cookie
+	a.commitSession(ctx)
 
 	if strings.ToLower(r.Header.Get("Upgrade")) != "websocket" {
 		Controller.exec()
 	}
 }
 
+// headResponseWriter отбрасывает тело ответа на HEAD-запрос
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *headResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
 func RegisterMiddleware(name string, plugins ...MiddlewareInterface) {
 	for _, plugin := range plugins {
 		app.definitions.Register(name, plugin)
@@ -411,6 +559,12 @@ func (a *App) Delete(url string, opts RouteOptions) {
 func (a *App) Options(url string, opts RouteOptions) {
 	a.addRoute(http.MethodOptions, url, &opts)
 }
+func (a *App) Patch(url string, opts RouteOptions) {
+	a.addRoute(http.MethodPatch, url, &opts)
+}
+func (a *App) Head(url string, opts RouteOptions) {
+	a.addRoute(http.MethodHead, url, &opts)
+}
 func (a *App) addRoute(method, url string, opts *RouteOptions) {
 	err := a.router.Add(method, url, opts)
 	if err != nil {
@@ -439,6 +593,12 @@ func Delete(url string, opts RouteOptions) {
 func Options(url string, opts RouteOptions) {
 	app.Options(url, opts)
 }
+func Patch(url string, opts RouteOptions) {
+	app.Patch(url, opts)
+}
+func Head(url string, opts RouteOptions) {
+	app.Head(url, opts)
+}
 
 func Tfunc(lang string) i18n.TFuncHandler {
 	return i18n.Tfunc(lang)
@@ -462,7 +622,7 @@ func Mail(address string, subject string, tpl string, model interface{}) (err er
 	}
 
 	buffer := bytes.NewBufferString("")
-	err = app.templates.ExecuteTemplate(buffer, tpl+".html", model)
+	err = app.executeTemplate(buffer, nil, tpl+".html", model)
 	if err != nil {
 		return
 	}
@@ -477,6 +637,10 @@ func Mail(address string, subject string, tpl string, model interface{}) (err er
 }
 
 func Run() {
+	if runCommand(os.Args[1:]) {
+		os.Exit(0)
+	}
+
 	var r *int = flag.Int("r", 0, "read timeout")
 	var w *int = flag.Int("w", 0, "write timeout")
 
@@ -2,17 +2,26 @@ package webgo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/IntelliQru/config"
 )
@@ -40,6 +49,7 @@ func TestMultyPartForm(t *testing.T) {
 	})
 
 	go Run()
+	waitServer(t)
 
 	// TEST
 
@@ -72,7 +82,7 @@ func TestMultyPartForm(t *testing.T) {
 				}
 
 				if srcData, ok := reqParams[file.Name]; !ok {
-					t.Errorf("Not found source file data: '%s'", file.Name, string(file.Name))
+					t.Errorf("Not found source file data: '%s'", file.Name)
 				} else if !bytes.Equal(srcData.([]byte), fileData) {
 					t.Errorf("Wrong file data '%s': [%q]!=[%q] ", file.Name, srcData, fileData)
 				}
@@ -128,6 +138,430 @@ func TestMultyPartForm(t *testing.T) {
 	wg.Wait()
 }
 
+func TestMethodNotAllowed(t *testing.T) {
+
+	Get("/test/allowed", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+	Put("/test/allowed", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+	Patch("/test/allowed/:id", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	TestControllerFunc = func(controller *TestController) {
+		controller.Plain("body")
+	}
+
+	for _, td := range []struct {
+		Method string
+		Url    string
+		Code   int
+		Allow  string
+		Body   string
+	}{
+		{http.MethodGet, "/test/allowed", 200, "", "body"},
+		{http.MethodHead, "/test/allowed", 200, "", ""},
+		{http.MethodPost, "/test/allowed", 405, "GET, HEAD, OPTIONS, PUT", "Method Not Allowed\n"},
+		{http.MethodOptions, "/test/allowed", 204, "GET, HEAD, OPTIONS, PUT", ""},
+		{http.MethodGet, "/test/allowed/1", 405, "OPTIONS, PATCH", "Method Not Allowed\n"},
+		{http.MethodPatch, "/test/allowed/1", 200, "", "body"},
+		{http.MethodGet, "/test/missing", 404, "", "Not Found\n"},
+	} {
+		res := serveRequest(td.Method, td.Url, nil, nil)
+
+		if res.Code != td.Code || res.Header().Get("Allow") != td.Allow || res.Body.String() != td.Body {
+			t.Errorf("Fail: %s %s -> %d '%s' %q", td.Method, td.Url, res.Code, res.Header().Get("Allow"), res.Body.String())
+		}
+	}
+}
+
+func TestTrailingSlashPolicy(t *testing.T) {
+
+	Get("/test/slash", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+	Post("/test/slash/dir/", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	TestControllerFunc = func(controller *TestController) {
+		controller.Plain(controller.Ctx.Request.URL.Path)
+	}
+
+	defer SetTrailingSlash(SlashRedirect)
+	defer SetCaseInsensitive(false)
+
+	for _, td := range []struct {
+		Policy   SlashPolicy
+		Fold     bool
+		Method   string
+		Url      string
+		Code     int
+		Location string
+	}{
+		{SlashRedirect, false, http.MethodGet, "/test/slash", 200, ""},
+		{SlashRedirect, false, http.MethodGet, "/test/slash/?a=1", 308, "/test/slash?a=1"},
+		{SlashRedirect, false, http.MethodPost, "/test/slash/dir", 308, "/test/slash/dir/"},
+		{SlashRedirect, false, http.MethodGet, "/test//slash", 308, "/test/slash"},
+		{SlashRedirect, false, http.MethodGet, "/test/x/../slash", 308, "/test/slash"},
+		{SlashRedirect, false, http.MethodGet, "/test/missing/", 404, ""},
+		{SlashBoth, false, http.MethodGet, "/test/slash/", 200, ""},
+		{SlashBoth, false, http.MethodGet, "/test//slash", 200, ""},
+		{SlashStrict, false, http.MethodGet, "/test/slash/", 404, ""},
+		{SlashStrict, false, http.MethodGet, "/TEST/Slash", 404, ""},
+		{SlashStrict, true, http.MethodGet, "/TEST/Slash", 200, ""},
+	} {
+		SetTrailingSlash(td.Policy)
+		SetCaseInsensitive(td.Fold)
+
+		res := serveRequest(td.Method, td.Url, nil, nil)
+		if res.Code != td.Code || res.Header().Get("Location") != td.Location {
+			t.Errorf("Fail: %d %s %s -> %d '%s'", td.Policy, td.Method, td.Url, res.Code, res.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+
+	HandleFunc(http.MethodGet, "/test/handle/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "handle ", RequestParams(r).Get("id"))
+	})
+
+	Mount("/test/mount", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Method, " ", r.URL.Path, " ", r.URL.RawQuery)
+	}))
+
+	for _, td := range []struct {
+		Method string
+		Url    string
+		Code   int
+		Body   string
+	}{
+		{http.MethodGet, "/test/handle/15", 200, "handle 15"},
+		{http.MethodPost, "/test/handle/15", 405, "Method Not Allowed\n"},
+		{http.MethodGet, "/test/mount", 200, "GET / "},
+		{http.MethodPost, "/test/mount/a/b?c=d", 200, "POST /a/b c=d"},
+		{http.MethodDelete, "/test/mount/a/", 200, "DELETE /a/ "},
+	} {
+		res := serveRequest(td.Method, td.Url, nil, nil)
+		if res.Code != td.Code || res.Body.String() != td.Body {
+			t.Errorf("Fail: %s %s -> %d %q", td.Method, td.Url, res.Code, res.Body.String())
+		}
+	}
+
+	// Обработчик получает путь, с которым маршрут был сопоставлен: без префикса в любом регистре и очищенный
+	defer SetTrailingSlash(SlashRedirect)
+	defer SetCaseInsensitive(false)
+	SetTrailingSlash(SlashBoth)
+	SetCaseInsensitive(true)
+
+	for url, body := range map[string]string{
+		"/TEST/Mount/x":       "GET /x ",
+		"/test/mount//x/../y": "GET /y ",
+		"/test/mount/./z?a=1": "GET /z a=1",
+	} {
+		res := serveRequest(http.MethodGet, url, nil, nil)
+		if res.Code != 200 || res.Body.String() != body {
+			t.Errorf("Fail: %s -> %d %q", url, res.Code, res.Body.String())
+		}
+	}
+}
+
+func TestActionTimeout(t *testing.T) {
+
+	Get("/test/timeout/:sleep", RouteOptions{
+		Controller: new(TestController),
+		Action:     "Invoke",
+		Timeout:    50 * time.Millisecond,
+	})
+
+	done := make(chan error, 1)
+	TestControllerFunc = func(controller *TestController) {
+		sleep, _ := controller.Ctx.Params.Int("sleep")
+
+		select {
+		case <-time.After(time.Duration(sleep) * time.Millisecond):
+			controller.Plain("done")
+			done <- nil
+		case <-controller.Ctx.Request.Context().Done():
+			controller.Plain("late")
+			done <- controller.Ctx.Request.Context().Err()
+		}
+	}
+
+	res := serveRequest(http.MethodGet, "/test/timeout/0", nil, nil)
+	if res.Code != 200 || res.Body.String() != "done" {
+		t.Errorf("Fail: %d %q", res.Code, res.Body.String())
+	}
+	<-done
+
+	res = serveRequest(http.MethodGet, "/test/timeout/1000", nil, nil)
+	if res.Code != 504 || res.Body.String() != "504 Gateway Timeout" {
+		t.Errorf("Fail: %d %q", res.Code, res.Body.String())
+	}
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Errorf("Context is not canceled: %v", err)
+	}
+
+	// Время чтения тела не входит в ограничение действия
+	Post("/test/timeout/:sleep", RouteOptions{
+		Controller: new(TestController),
+		Action:     "Invoke",
+		Timeout:    50 * time.Millisecond,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test/timeout/0", &slowReader{data: []byte("a=12345678"), delay: 100 * time.Millisecond})
+	req.Header.Set("Content-Type", CT_FORM)
+
+	res = httptest.NewRecorder()
+	app.ServeHTTP(res, req)
+	if res.Code != 200 || res.Body.String() != "done" {
+		t.Errorf("Fail: slow body %d %q", res.Code, res.Body.String())
+	}
+	<-done
+
+	// Timeout без единиц измерения отклоняется
+	err := new(Router).Add(http.MethodGet, "/", &RouteOptions{Controller: new(TestController), Action: "Invoke", Timeout: 5})
+	if err == nil {
+		t.Error("Timeout 5ns accepted")
+	}
+}
+
+// slowReader отдает данные после задержки, как медленный клиент
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.delay > 0 {
+		time.Sleep(r.delay)
+		r.delay = 0
+	}
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestPanicRecovery(t *testing.T) {
+
+	Get("/test/panic", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	TestControllerFunc = func(controller *TestController) {
+		controller.SetHeader("X-Partial", "1")
+		panic("test panic")
+	}
+
+	var recovered interface{}
+	OnPanic(func(ctx *Context, err interface{}) {
+		recovered = err
+	})
+	defer OnPanic(nil)
+
+	res := serveRequest(http.MethodGet, "/test/panic", http.Header{"Accept": {"application/json"}}, nil)
+	if res.Code != 500 || res.Header().Get("Content-Type") != "application/problem+json; charset=utf-8" {
+		t.Errorf("Fail: %d %q", res.Code, res.Body.String())
+	}
+	if recovered != "test panic" {
+		t.Errorf("Panic handler is not called: %v", recovered)
+	}
+
+	res = serveRequest(http.MethodGet, "/test/panic", http.Header{"Accept": {"text/html"}}, nil)
+	if res.Code != 500 || res.Body.String() != "Internal Server Error\n" {
+		t.Errorf("Fail: %d %q", res.Code, res.Body.String())
+	}
+
+	// Паника вне действия, например в обработчике ошибок
+	ErrorHandler(func(ctx *Context, err error) {
+		panic("error handler panic")
+	})
+	defer ErrorHandler(nil)
+
+	res = serveRequest(http.MethodGet, "/test/panic/missing", nil, nil)
+	if res.Code != 500 || res.Body.String() != "Internal Server Error\n" {
+		t.Errorf("Fail: %d %q", res.Code, res.Body.String())
+	}
+	if recovered != "error handler panic" {
+		t.Errorf("Panic handler is not called: %v", recovered)
+	}
+}
+
+type ErrorController struct {
+	Controller
+}
+
+func (c *ErrorController) NotFound() error {
+	return &HTTPError{Code: 404, Message: "User not found", Details: map[string]string{"id": c.Ctx.Params.Get("id")}}
+}
+
+func (c *ErrorController) Failed() error {
+	return errors.New("internal details")
+}
+
+func (c *ErrorController) Ok() error {
+	c.Plain("ok")
+	return nil
+}
+
+func (c *ErrorController) NoCode() error {
+	return &HTTPError{Err: errors.New("missing code")}
+}
+
+func TestActionError(t *testing.T) {
+
+	Get("/test/error/notfound/:id", RouteOptions{Controller: new(ErrorController), Action: "NotFound"})
+	Get("/test/error/failed", RouteOptions{Controller: new(ErrorController), Action: "Failed"})
+	Get("/test/error/ok", RouteOptions{Controller: new(ErrorController), Action: "Ok"})
+	Get("/test/error/nocode", RouteOptions{Controller: new(ErrorController), Action: "NoCode"})
+	Post("/test/error/limit", RouteOptions{Controller: new(ErrorController), Action: "Ok", BodyLength: 10})
+
+	jsonHeader := http.Header{"Accept": {"application/json"}}
+
+	for _, td := range []struct {
+		Method string
+		Url    string
+		Header http.Header
+		Body   []byte
+		Code   int
+		Res    string
+	}{
+		{http.MethodGet, "/test/error/ok", nil, nil, 200, "ok"},
+		{http.MethodGet, "/test/error/notfound/7", nil, nil, 404, "User not found\n"},
+		{http.MethodGet, "/test/error/notfound/7", jsonHeader, nil, 404, `{"detail":"User not found","details":{"id":"7"},"status":404,"title":"Not Found","type":"about:blank"}`},
+		{http.MethodGet, "/test/error/failed", nil, nil, 500, "Internal Server Error\n"},
+		{http.MethodGet, "/test/error/nocode", nil, nil, 500, "Internal Server Error\n"},
+		{http.MethodGet, "/test/missing", jsonHeader, nil, 404, `{"detail":"Not Found","status":404,"title":"Not Found","type":"about:blank"}`},
+		{http.MethodPost, "/test/error/limit", http.Header{"Content-Type": {CT_JSON}}, []byte(`{"data":"too long body"}`), 413, `{"detail":"Request Entity Too Large","status":413,"title":"Request Entity Too Large","type":"about:blank"}`},
+	} {
+		res := serveRequest(td.Method, td.Url, td.Header, td.Body)
+		if res.Code != td.Code || res.Body.String() != td.Res {
+			t.Errorf("Fail: %s %s -> %d %q", td.Method, td.Url, res.Code, res.Body.String())
+		}
+	}
+
+	var handled error
+	ErrorHandler(func(ctx *Context, err error) {
+		handled = err
+		DefaultErrorHandler(ctx, err)
+	})
+	defer ErrorHandler(nil)
+
+	serveRequest(http.MethodGet, "/test/error/failed", nil, nil)
+	if handled == nil || handled.Error() != "internal details" {
+		t.Errorf("Error handler is not called: %v", handled)
+	}
+}
+
+type SearchQuery struct {
+	ID   int      `path:"id"`
+	Q    string   `query:"q"`
+	Tags []string `query:"tag"`
+	Name string   `json:"name"`
+}
+
+type UsersController struct {
+	Controller
+}
+
+func (c *UsersController) Show(id int, q *SearchQuery) error {
+	c.Plain(fmt.Sprintf("%d %d %s %v %s", id, q.ID, q.Q, q.Tags, q.Name))
+	return nil
+}
+
+func (c *UsersController) Search(q SearchQuery) {
+	c.Plain(q.Q)
+}
+
+func (c *UsersController) Page(id int, page int) {}
+
+func (c *UsersController) Raw(data map[string]string) {}
+
+func TestActionParams(t *testing.T) {
+
+	Get("/test/users/:id", RouteOptions{Controller: new(UsersController), Action: "Show"})
+	Post("/test/users/:id", RouteOptions{Controller: new(UsersController), Action: "Show"})
+
+	jsonHeader := http.Header{"Content-Type": {CT_JSON}}
+
+	for _, td := range []struct {
+		Method string
+		Url    string
+		Header http.Header
+		Body   []byte
+		Code   int
+		Res    string
+	}{
+		{http.MethodGet, "/test/users/42?q=go&tag=a&tag=b", nil, nil, 200, "42 42 go [a b] "},
+		{http.MethodPost, "/test/users/7", jsonHeader, []byte(`{"name":"John"}`), 200, "7 7  [] John"},
+		{http.MethodGet, "/test/users/abc", nil, nil, 400, "Invalid value 'abc' for 'id', must be int\n"},
+		{http.MethodPost, "/test/users/7", jsonHeader, []byte(`{"name":1}`), 400, ""},
+	} {
+		res := serveRequest(td.Method, td.Url, td.Header, td.Body)
+		if res.Code != td.Code || (td.Res != "" && res.Body.String() != td.Res) {
+			t.Errorf("Fail: %s %s -> %d %q", td.Method, td.Url, res.Code, res.Body.String())
+		}
+	}
+}
+
+// parseTestTemplate добавляет шаблон в набор приложения
+func parseTestTemplate(name, text string) {
+	template.Must(app.templates.New(name + ".html").Parse(text))
+	app.resetTemplates()
+}
+
+func TestErrorPages(t *testing.T) {
+
+	parseTestTemplate("test_404", `404 {{.Path}} {{.Message}} {{.Lang}}`)
+	parseTestTemplate("test_error", `error {{.Code}}`)
+
+	SetErrorPage(404, "test_404")
+	SetErrorTemplate("test_error")
+	defer SetErrorPage(404, "")
+	defer SetErrorTemplate("")
+
+	Get("/test/pages", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	for _, td := range []struct {
+		Method string
+		Url    string
+		Header http.Header
+		Code   int
+		Res    string
+	}{
+		{http.MethodGet, "/test/pages/missing", nil, 404, "404 /test/pages/missing Not Found en-US"},
+		{http.MethodGet, "/test/pages/missing", http.Header{"Accept-Language": {"ru-RU,en;q=0.8"}}, 404, "404 /test/pages/missing Not Found ru-RU"},
+		{http.MethodPost, "/test/pages", nil, 405, "error 405"},
+		{http.MethodGet, "/test/pages/missing", http.Header{"Accept": {"application/json"}}, 404, `{"detail":"Not Found","status":404,"title":"Not Found","type":"about:blank"}`},
+	} {
+		res := serveRequest(td.Method, td.Url, td.Header, nil)
+		if res.Code != td.Code || res.Body.String() != td.Res {
+			t.Errorf("Fail: %s %s -> %d %q", td.Method, td.Url, res.Code, res.Body.String())
+		}
+	}
+}
+
+func serveRequest(method, url string, header http.Header, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	for k := range header {
+		req.Header[k] = header[k]
+	}
+
+	res := httptest.NewRecorder()
+	app.ServeHTTP(res, req)
+	return res
+}
+
+func waitServer(t *testing.T) {
+	address := net.JoinHostPort(CFG.Str("host"), strconv.Itoa(CFG.Int("port")))
+
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatal("Server is not started:", address)
+}
+
 func sendRequest(t *testing.T, uri string, header http.Header, body []byte) (int, http.Header, []byte) {
 
 	var (
@@ -260,3 +694,522 @@ func removeConfig(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+type SchemaAddress struct {
+	City string `form:"city" json:"city"`
+	Zip  *int   `form:"zip" json:"zip"`
+}
+
+type SchemaProfile struct {
+	FirstName string         `form:"first_name" json:"first_name"`
+	Age       uint8          `form:"age" json:"age"`
+	Active    bool           `form:"active" json:"active"`
+	Score     *float64       `form:"score" json:"score"`
+	Born      time.Time      `form:"born" json:"born"`
+	Tags      []string       `form:"tags" json:"tags"`
+	Address   SchemaAddress  `form:"address" json:"address"`
+	Work      *SchemaAddress `form:"work" json:"work"`
+	Token     string         `header:"X-Token"`
+	Session   string         `cookie:"sid"`
+	Page      int            `query:"page"`
+	Comment   string
+}
+
+func TestValidateSchema(t *testing.T) {
+
+	multipartBody := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(multipartBody)
+	for _, field := range [][2]string{
+		{"first_name", "John"}, {"age", "30"}, {"active", "true"}, {"born", "2020-01-02T00:00:00Z"},
+		{"tags[]", "a"}, {"tags[]", "b"}, {"address[city]", "Moscow"}, {"address[zip]", "101000"}, {"Comment", "hi"},
+	} {
+		writer.WriteField(field[0], field[1])
+	}
+	writer.Close()
+
+	form := "first_name=John&age=30&active=true&born=2020-01-02T00:00:00Z&tags[]=a&tags[]=b&address[city]=Moscow&address.zip=101000&Comment=hi"
+
+	for _, td := range []struct {
+		Method      string
+		Url         string
+		ContentType string
+		Body        []byte
+	}{
+		{http.MethodPost, "/?page=2", CT_FORM, []byte(form)},
+		{http.MethodPost, "/?page=2", writer.FormDataContentType(), multipartBody.Bytes()},
+		{http.MethodPost, "/?page=2", CT_JSON, []byte(`{"first_name":"John","age":30,"active":true,"born":"2020-01-02T00:00:00Z",
+			"tags":["a","b"],"address":{"city":"Moscow","zip":101000},"Comment":"hi"}`)},
+		{http.MethodGet, "/?page=2&" + form, "", nil},
+	} {
+		req := httptest.NewRequest(td.Method, td.Url, bytes.NewReader(td.Body))
+		req.Header.Set("Content-Type", td.ContentType)
+		req.Header.Set("X-Token", "secret")
+		req.AddCookie(&http.Cookie{Name: "sid", Value: "s1"})
+
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		ctx.ContentType, _, _ = mime.ParseMediaType(td.ContentType)
+
+		if _, err := parseRequest(ctx, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+
+		var profile SchemaProfile
+		if err := ctx.ValidateSchema(&profile); err != nil {
+			t.Errorf("Fail: %s %s", td.ContentType, err)
+			continue
+		}
+
+		res := fmt.Sprintf("%s %d %v %v %s %v %s %d %v %s %s %d %s", profile.FirstName, profile.Age, profile.Active, profile.Score,
+			profile.Born.Format(DateLayout), profile.Tags, profile.Address.City, *profile.Address.Zip, profile.Work,
+			profile.Token, profile.Session, profile.Page, profile.Comment)
+
+		if res != "John 30 true <nil> 2020-01-02 [a b] Moscow 101000 <nil> secret s1 2 hi" {
+			t.Errorf("Fail: %s %s", td.ContentType, res)
+		}
+	}
+
+	// Поля без тегов привязки заполняются по имени поля, как раньше, и по имени из тега json
+	var legacy struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("FirstName=John&last_name=Doe"))
+	req.Header.Set("Content-Type", CT_FORM)
+	ctx := newContext(httptest.NewRecorder(), req, nil)
+	ctx.ContentType = CT_FORM
+	parseRequest(ctx, 1<<20)
+
+	if err := ctx.ValidateSchema(&legacy); err != nil || legacy.FirstName != "John" || legacy.LastName != "Doe" {
+		t.Errorf("Fail: %+v %v", legacy, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/?age=300", nil)
+	ctx = newContext(httptest.NewRecorder(), req, nil)
+	parseRequest(ctx, 1<<20)
+
+	var profile SchemaProfile
+	if err := ctx.ValidateSchema(&profile); err == nil || err.Error() != "Invalid value '300' for 'age', must be uint8" {
+		t.Errorf("Fail: %v", err)
+	}
+
+	// Тело JSON связывается один раз, ошибочное поле дает одну ошибку
+	var person struct {
+		Age int `json:"age"`
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"age":"abc"}`))
+	ctx = newContext(httptest.NewRecorder(), req, nil)
+	ctx.ContentType = CT_JSON
+	parseRequest(ctx, 1<<20)
+
+	errs, ok := ctx.ValidateSchema(&person).(ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Field != "age" {
+		t.Errorf("Fail: JSON field errors %v", errs)
+	}
+
+	// В сообщении - значение, переданное клиентом, а не тип JSON
+	if ok && errs.Error() != "Invalid value 'abc' for 'age', must be int" {
+		t.Errorf("Fail: JSON field message %q", errs.Error())
+	}
+}
+
+type SignupForm struct {
+	Login    string   `form:"login" validate:"required,min=3,max=8,regex=[a-z0-9,]+"`
+	Email    string   `form:"email" validate:"required,email"`
+	Age      *int     `form:"age" validate:"required,min=18"`
+	Role     string   `form:"role" validate:"omitempty,oneof=user admin"`
+	Phone    string   `form:"phone" validate:"omitempty,phone"`
+	Tags     []string `form:"tags" validate:"max=2"`
+	Nickname string   `form:"nickname" validate:"omitempty,min=2"`
+}
+
+type ZeroForm struct {
+	Age   int      `form:"age" validate:"min=18"`
+	Kind  int      `form:"kind" validate:"oneof=1 2"`
+	Tags  []string `form:"tags" validate:"min=1"`
+	Limit *int     `form:"limit" validate:"min=1"`
+}
+
+func TestValidationRules(t *testing.T) {
+
+	RegisterValidator("phone", func(value reflect.Value, param string) bool {
+		return strings.HasPrefix(value.String(), "+")
+	})
+
+	for _, td := range []struct {
+		Query  string
+		Errors string
+	}{
+		{"login=john&email=john@example.com&age=20&role=admin&phone=%2B7&tags=a", ""},
+		{"login=jo&email=john&role=root&phone=7&tags=a&tags=b&tags=c",
+			"login:min:3 email:email: age:required: role:oneof:user admin phone:phone: tags:max:2"},
+		{"login=JOHN&email=john@example.com&age=x", "age:type:int login:regex:[a-z0-9,]+"},
+		{"login=johnjohnjohn&email=john@example.com&age=0", "login:max:8 age:min:18"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+td.Query, nil)
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		parseRequest(ctx, 1<<20)
+
+		var form SignupForm
+		err := ctx.ValidateSchema(&form)
+
+		var res []string
+		if errs, ok := err.(ValidationErrors); ok {
+			for _, e := range errs {
+				res = append(res, e.Field+":"+e.Rule+":"+e.Param)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(res, " ") != td.Errors {
+			t.Errorf("Fail: %s -> %v", td.Query, err)
+		}
+	}
+
+	// Нулевые числа и пустой срез не считаются отсутствующими значениями
+	for _, td := range []struct {
+		Query  string
+		Errors string
+	}{
+		{"age=0&kind=0", "age:min kind:oneof tags:min"},
+		{"", "age:min kind:oneof tags:min"},
+		{"age=18&kind=2&tags=a", ""},
+		{"age=18&kind=2&tags=a&limit=0", "limit:min"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+td.Query, nil)
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		parseRequest(ctx, 1<<20)
+
+		var form ZeroForm
+		err := ctx.ValidateSchema(&form)
+
+		var res []string
+		if errs, ok := err.(ValidationErrors); ok {
+			for _, e := range errs {
+				res = append(res, e.Field+":"+e.Rule)
+			}
+		}
+
+		if strings.Join(res, " ") != td.Errors {
+			t.Errorf("Fail: %s -> %v", td.Query, err)
+		}
+	}
+
+	if _, err := parseRules("required,unknown"); err == nil {
+		t.Error("Unknown rule accepted")
+	}
+}
+
+func TestJSONBody(t *testing.T) {
+
+	for _, td := range []struct {
+		Body string
+		Data string
+		Map  int
+	}{
+		{`[1, 2, 12345678901234567890]`, "[1 2 12345678901234567890]", 0},
+		{`"text"`, "text", 0},
+		{` 3.14 `, "3.14", 0},
+		{`{"id": 1}`, "map[id:1]", 1},
+		{`{"id": 12345678901234567890}`, "map[id:12345678901234567890]", 1},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(td.Body))
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		ctx.ContentType = CT_JSON
+
+		if _, err := parseRequest(ctx, 1<<20); err != nil {
+			t.Errorf("Fail: %s %s", td.Body, err)
+			continue
+		}
+
+		data, err := ctx.Data()
+		if err != nil || fmt.Sprint(data) != td.Data || len(ctx.Body) != td.Map || string(ctx.GetBody()) != td.Body {
+			t.Errorf("Fail: %s -> %v %v %v", td.Body, data, ctx.Body, err)
+		}
+
+		// Body и Data возвращают одно значение, числа без потери точности
+		if _, ok := ctx.Body["id"]; ok && ctx.Body["id"] != data.(map[string]interface{})["id"] {
+			t.Errorf("Fail: %s Body %T differs from Data", td.Body, ctx.Body["id"])
+		}
+	}
+
+	for _, body := range []string{`[1, 2`, `{"id": 1} x`, `{}{}`} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		ctx.ContentType = CT_JSON
+
+		if code, err := parseRequest(ctx, 1<<20); code != 400 || err == nil {
+			t.Errorf("Invalid JSON accepted: %s %d %v", body, code, err)
+		}
+	}
+
+	var schema struct {
+		Name string `json:"name"`
+	}
+
+	for _, strict := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"John","extra":1}`))
+		ctx := newContext(httptest.NewRecorder(), req, &Match{Options: &RouteOptions{StrictJSON: strict}})
+		ctx.ContentType = CT_JSON
+		parseRequest(ctx, 1<<20)
+
+		err := ctx.ValidateSchema(&schema)
+		if errs, ok := err.(ValidationErrors); strict != ok || (ok && errs[0].Field != "extra") {
+			t.Errorf("Fail: strict %v %v", strict, err)
+		}
+	}
+}
+
+type DecodedUser struct {
+	Name string   `form:"name"`
+	Age  int      `form:"age"`
+	Tags []string `form:"tags"`
+}
+
+func TestDecoders(t *testing.T) {
+
+	RegisterDecoder("application/x-lines", DecoderFunc(func(r io.Reader, v interface{}) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		res := make(map[string]interface{})
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if i := strings.IndexByte(line, '='); i > 0 {
+				res[line[:i]] = line[i+1:]
+			}
+		}
+
+		// Структуры заполняются по тегам form из разобранного значения
+		if target, ok := v.(*interface{}); ok {
+			*target = res
+		}
+		return nil
+	}))
+
+	msgpack := []byte("\x83\xa4name\xa4John\xa3age\x1e\xa4tags\x92\xa1a\xa1b")
+
+	for _, td := range []struct {
+		ContentType string
+		Body        []byte
+	}{
+		{"application/xml; charset=utf-8", []byte(`<user age="30"><name>John</name><tags>a</tags><tags>b</tags></user>`)},
+		{"application/problem+json", []byte(`{"name":"John","age":30,"tags":["a","b"]}`)},
+		{CT_MSGPACK, msgpack},
+		{"application/x-lines", []byte("name=John\nage=30\ntags=a")},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(td.Body))
+		ctx := newContext(httptest.NewRecorder(), req, nil)
+		ctx.ContentType, _, _ = mime.ParseMediaType(td.ContentType)
+
+		if _, err := parseRequest(ctx, 1<<20); err != nil {
+			t.Errorf("Fail: %s %s", td.ContentType, err)
+			continue
+		}
+
+		if fmt.Sprint(ctx.Body["name"]) != "John" {
+			t.Errorf("Fail: %s body %v", td.ContentType, ctx.Body)
+		}
+
+		var user DecodedUser
+		if err := ctx.ValidateSchema(&user); err != nil || user.Name != "John" || user.Age != 30 || len(user.Tags) == 0 || user.Tags[0] != "a" {
+			t.Errorf("Fail: %s %+v %v", td.ContentType, user, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	ctx := newContext(httptest.NewRecorder(), req, nil)
+	ctx.ContentType = CT_TEXT
+	parseRequest(ctx, 1<<20)
+
+	if data, err := ctx.Data(); err != nil || data != "hello" {
+		t.Errorf("Fail: text %v %v", data, err)
+	}
+
+	// Потоковое тело не читается при разборе запроса
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("raw bytes"))
+	ctx = newContext(httptest.NewRecorder(), req, nil)
+	ctx.ContentType = CT_OCTET_STREAM
+	parseRequest(ctx, 1<<20)
+
+	if len(ctx.GetBody()) != 0 {
+		t.Errorf("Stream body is buffered: %q", ctx.GetBody())
+	}
+	if data, err := ioutil.ReadAll(ctx.Request.Body); err != nil || string(data) != "raw bytes" {
+		t.Errorf("Fail: stream %q %v", data, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a,b"))
+	ctx = newContext(httptest.NewRecorder(), req, nil)
+	ctx.ContentType = "text/csv"
+
+	if code, err := parseRequest(ctx, 1<<20); code != 400 || err == nil {
+		t.Errorf("Unknown content type accepted: %d %v", code, err)
+	}
+}
+
+type UploadController struct {
+	Controller
+}
+
+func (c *UploadController) Prepare() bool {
+	return c.Ctx.Request.URL.Query().Get("deny") == ""
+}
+
+func (c *UploadController) Save() {
+	var res []string
+	for _, file := range c.Ctx.Files {
+		data, _ := ioutil.ReadFile(file.Path)
+		res = append(res, fmt.Sprintf("%s:%d:%s", file.Name, file.Size, data))
+	}
+	c.Plain(strings.Join(res, " ") + " " + fmt.Sprint(c.Ctx.Body["field"]))
+}
+
+func (c *UploadController) Stream() error {
+	var res []string
+	for {
+		part, err := c.Ctx.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		res = append(res, fmt.Sprintf("%s:%s", part.FormName(), data))
+	}
+
+	c.Plain(strings.Join(res, " "))
+	return nil
+}
+
+func TestMultipartUpload(t *testing.T) {
+
+	Post("/test/upload", RouteOptions{Controller: new(UploadController), Action: "Save", MaxFiles: 2, MaxFileSize: 10})
+	Post("/test/upload/stream", RouteOptions{Controller: new(UploadController), Action: "Stream", StreamMultipart: true, MaxFileSize: 10})
+
+	tmpFiles := func() int {
+		files, _ := filepath.Glob(filepath.Join(os.TempDir(), "multipart-*"))
+		return len(files)
+	}
+	before := tmpFiles()
+
+	form := func(files ...string) (http.Header, []byte) {
+		body := bytes.NewBuffer(nil)
+		writer := multipart.NewWriter(body)
+		writer.WriteField("field", "value")
+		for i, data := range files {
+			part, _ := writer.CreateFormFile(fmt.Sprint("file", i), fmt.Sprint("file", i))
+			part.Write([]byte(data))
+		}
+		writer.Close()
+		return http.Header{"Content-Type": {writer.FormDataContentType()}}, body.Bytes()
+	}
+
+	for _, td := range []struct {
+		Url   string
+		Files []string
+		Code  int
+		Res   string
+	}{
+		{"/test/upload", []string{"one", "two"}, 200, "file0:3:one file1:3:two [value]"},
+		{"/test/upload", []string{"one", "two", "three"}, 413, ""},
+		{"/test/upload", []string{"one", "too long content"}, 413, ""},
+		{"/test/upload?deny=1", []string{"one"}, 200, ""},
+		{"/test/upload/stream", []string{"one", "two"}, 200, "field:value file0:one file1:two"},
+		{"/test/upload/stream", []string{"too long content"}, 413, ""},
+	} {
+		header, body := form(td.Files...)
+
+		res := serveRequest(http.MethodPost, td.Url, header, body)
+		if res.Code != td.Code || (td.Res != "" && res.Body.String() != td.Res) {
+			t.Errorf("Fail: %s %v -> %d %q", td.Url, td.Files, res.Code, res.Body.String())
+		}
+
+		if tmpFiles() != before {
+			t.Errorf("Temp files are not removed: %s %v", td.Url, td.Files)
+		}
+	}
+
+	// Файлы доступны и через Request.MultipartForm и Request.FormFile
+	Post("/test/upload/form", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	TestControllerFunc = func(controller *TestController) {
+		r := controller.Ctx.Request
+
+		file, fh, err := r.FormFile("file0")
+		if err != nil {
+			controller.Plain(err.Error())
+			return
+		}
+		defer file.Close()
+
+		data, _ := ioutil.ReadAll(file)
+		controller.Plain(fmt.Sprint(fh.Filename, ":", string(data), " ", r.MultipartForm.Value["field"], " ", len(controller.Ctx.Files)))
+	}
+
+	header, body := form("one")
+	res := serveRequest(http.MethodPost, "/test/upload/form", header, body)
+	if res.Code != 200 || res.Body.String() != "file0:one [value] 1" {
+		t.Errorf("Fail: multipart form %d %q", res.Code, res.Body.String())
+	}
+	if tmpFiles() != before {
+		t.Error("Temp files are not removed: multipart form")
+	}
+}
+
+func TestCookies(t *testing.T) {
+	defer SetCookieDefaults(app.cookieDefaults)
+	SetCookieDefaults(CookieDefaults{Secure: true, SameSite: http.SameSiteLaxMode})
+
+	Get("/test/cookies", RouteOptions{Controller: new(TestController), Action: "Invoke"})
+
+	value := `a b;c,"d"\100%ё`
+
+	TestControllerFunc = func(controller *TestController) {
+		ctx := controller.Ctx
+		controller.Plain(ctx.GetCookie("value"))
+
+		ctx.SetCookieOpts(&http.Cookie{Name: "value", Value: value, Path: "/test", MaxAge: 60})
+		ctx.SetCookieOpts(&http.Cookie{Name: "none", Value: "1", SameSite: http.SameSiteNoneMode, Unparsed: []string{"Partitioned"}})
+		ctx.SetCookie("legacy", "1", 60, "/", "", true)
+		ctx.DeleteCookie("old", "/test", "example.com")
+	}
+
+	res := serveRequest(http.MethodGet, "/test/cookies", nil, nil)
+	headers := res.Result().Header["Set-Cookie"]
+	if len(headers) != 4 {
+		t.Fatalf("Invalid cookies: %v", headers)
+	}
+
+	cookies := res.Result().Cookies()
+	for i, expected := range []string{
+		"value=a%20b%3Bc%2C%22d%22%5C100%25%D1%91; Path=/test; Expires=",
+		"none=1; Secure; SameSite=None; Partitioned",
+		"legacy=1; Path=/; Expires=",
+		"old=; Path=/test; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; Secure; SameSite=Lax",
+	} {
+		if !strings.HasPrefix(headers[i], expected) {
+			t.Errorf("Fail: %q, expected %q", headers[i], expected)
+		}
+		if !cookies[i].Secure {
+			t.Errorf("Default Secure is not applied: %q", headers[i])
+		}
+	}
+	if cookies[2].MaxAge != 60 || !cookies[2].HttpOnly || cookies[2].SameSite != http.SameSiteLaxMode {
+		t.Errorf("Fail: legacy cookie %q", headers[2])
+	}
+
+	// Значение читается в исходном виде
+	res = serveRequest(http.MethodGet, "/test/cookies", http.Header{"Cookie": {"value=" + cookies[0].Value}}, nil)
+	if res.Body.String() != value {
+		t.Errorf("Fail: cookie value %q", res.Body.String())
+	}
+}